@@ -0,0 +1,87 @@
+;; Asset transfer contract: hands an NFT over to a new owner once the owner
+;; approves the transfer in their wallet. The input of transfer_asset is
+;; passed as is to the do_transfer_nft host function, and its reply, or the
+;; outcome of the rejected command, is the output of the contract.
+;;
+;; Built with: wat2wasm asset_transfer_contract.wat -o asset_transfer_contract.wasm
+(module
+  (import "env" "do_transfer_nft" (func $do_transfer_nft (param i32 i32 i32 i32) (result i32)))
+
+  (memory (export "memory") 1)
+
+  ;; Start of the free memory. An instance serves a single call, so memory
+  ;; is never given back.
+  (global $heap (mut i32) (i32.const 1024))
+
+  (func $alloc (export "alloc") (param $size i32) (result i32)
+    (local $ptr i32)
+    (local $end i32)
+    (local.set $ptr (global.get $heap))
+    (local.set $end
+      (i32.and
+        (i32.add (i32.add (local.get $ptr) (local.get $size)) (i32.const 7))
+        (i32.const -8)))
+    (if (i32.gt_u (local.get $end) (i32.mul (memory.size) (i32.const 65536)))
+      (then
+        (if (i32.eq
+              (memory.grow
+                (i32.sub
+                  (i32.div_u (i32.add (local.get $end) (i32.const 65535)) (i32.const 65536))
+                  (memory.size)))
+              (i32.const -1))
+          (then unreachable))))
+    (global.set $heap (local.get $end))
+    (local.get $ptr))
+
+  (func (export "dealloc") (param $ptr i32) (param $size i32))
+
+  (func (export "transfer_asset_")
+    (param $input_ptr i32) (param $input_len i32)
+    (param $output_ptr_ptr i32) (param $output_len_ptr i32)
+    (result i32)
+    (local $resp i32)
+    (local $resp_ptr i32)
+    (local $resp_len i32)
+    (local $code i32)
+    (local $output i32)
+    (local $len i32)
+    (local $i i32)
+    (local $byte i32)
+
+    ;; Pointer and length of the reply written by the host function
+    (local.set $resp (call $alloc (i32.const 8)))
+    (i32.store (local.get $resp) (i32.const 0))
+    (i32.store offset=4 (local.get $resp) (i32.const 0))
+
+    (local.set $code
+      (call $do_transfer_nft
+        (local.get $input_ptr) (local.get $input_len)
+        (local.get $resp) (i32.add (local.get $resp) (i32.const 4))))
+    (local.set $resp_ptr (i32.load (local.get $resp)))
+    (local.set $resp_len (i32.load offset=4 (local.get $resp)))
+
+    ;; The output of a contract is a JSON string, so the reply is quoted
+    (local.set $output
+      (call $alloc (i32.add (i32.mul (local.get $resp_len) (i32.const 2)) (i32.const 2))))
+    (i32.store8 (local.get $output) (i32.const 34))
+    (local.set $len (i32.const 1))
+    (block $done
+      (loop $copy
+        (br_if $done (i32.ge_u (local.get $i) (local.get $resp_len)))
+        (local.set $byte (i32.load8_u (i32.add (local.get $resp_ptr) (local.get $i))))
+        (if (i32.or
+              (i32.eq (local.get $byte) (i32.const 34))
+              (i32.eq (local.get $byte) (i32.const 92)))
+          (then
+            (i32.store8 (i32.add (local.get $output) (local.get $len)) (i32.const 92))
+            (local.set $len (i32.add (local.get $len) (i32.const 1)))))
+        (i32.store8 (i32.add (local.get $output) (local.get $len)) (local.get $byte))
+        (local.set $len (i32.add (local.get $len) (i32.const 1)))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        (br $copy)))
+    (i32.store8 (i32.add (local.get $output) (local.get $len)) (i32.const 34))
+    (local.set $len (i32.add (local.get $len) (i32.const 1)))
+
+    (i32.store (local.get $output_ptr_ptr) (local.get $output))
+    (i32.store (local.get $output_len_ptr) (local.get $len))
+    (local.get $code)))
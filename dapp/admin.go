@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"dapp/wallet"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminSession is the view of a wallet session given to operators. The
+// schema version negotiated by the session is part of wallet.Session.
+type AdminSession struct {
+	wallet.Session
+	wallet.BrokerStats
+}
+
+// requireAdmin only lets through the requests carrying the ADMIN_TOKEN
+// environment variable as a bearer token. The admin endpoints are disabled
+// when ADMIN_TOKEN is not set, as they can disconnect wallets and cancel
+// their commands.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if os.Getenv("ADMIN_TOKEN") == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "admin endpoints are disabled, ADMIN_TOKEN is not set"})
+			return
+		}
+
+		if !isAdmin(c) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid admin token"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// registerSessionRoutes serves the routes listing and switching the wallet
+// sessions of a DID, and the operator view of all the sessions. A caller
+// choosing the active session of a DID receives its wallet commands, so
+// they are all admin endpoints.
+func (s *Server) registerSessionRoutes(r gin.IRouter) {
+	r.GET("/client-sessions", requireAdmin(), s.handleClientSessions)
+	r.POST("/client-sessions/active", requireAdmin(), s.handleSetActiveSession)
+
+	admin := r.Group("/admin", requireAdmin())
+	admin.GET("/sessions", s.handleAdminSessions)
+	admin.POST("/sessions/:id/disconnect", s.handleAdminDisconnect)
+	admin.POST("/sessions/:id/requests/:requestID/cancel", s.handleAdminCancelRequest)
+}
+
+// isAdmin tells whether the request carries the ADMIN_TOKEN as a bearer
+// token. No request does when ADMIN_TOKEN is not set.
+func isAdmin(c *gin.Context) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return false
+	}
+
+	provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// handleAdminSessions lists every wallet session, or those of the DID given
+// in the clientID query parameter, with their pending commands
+func (s *Server) handleAdminSessions(c *gin.Context) {
+	var sessions []wallet.Session
+	if clientID := c.Query("clientID"); clientID != "" {
+		sessions = TrieClients.Sessions(clientID)
+	} else {
+		sessions = TrieClients.All()
+	}
+
+	adminSessions := make([]AdminSession, 0, len(sessions))
+	for _, session := range sessions {
+		adminSessions = append(adminSessions, AdminSession{
+			Session:     session,
+			BrokerStats: session.Broker.Stats(),
+		})
+	}
+
+	c.JSON(http.StatusOK, adminSessions)
+}
+
+// handleAdminDisconnect closes a session right away, without waiting for its
+// wallet to reconnect. Its pending commands fail.
+func (s *Server) handleAdminDisconnect(c *gin.Context) {
+	session, ok := TrieClients.Find(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("session %v not found", c.Param("id"))})
+		return
+	}
+
+	fmt.Printf("Disconnecting session %v of %v on operator request\n", session.ID, session.DID)
+	session.Broker.Close()
+
+	wrapSuccess(c.JSON, fmt.Sprintf("Session %v of %v disconnected", session.ID, session.DID))
+}
+
+// handleAdminCancelRequest fails a pending command of a session, which is
+// reported to its contract as a wallet error
+func (s *Server) handleAdminCancelRequest(c *gin.Context) {
+	session, ok := TrieClients.Find(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("session %v not found", c.Param("id"))})
+		return
+	}
+
+	if err := session.Broker.Cancel(c.Param("requestID")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+
+	fmt.Printf("Cancelled command %v of session %v on operator request\n", c.Param("requestID"), session.ID)
+	c.JSON(http.StatusOK, AdminSession{
+		Session:     TrieClients.Snapshot(session),
+		BrokerStats: session.Broker.Stats(),
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSessionRoutesRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	(&Server{}).registerSessionRoutes(r)
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		header string
+		status int
+	}{
+		{
+			name:   "unauthenticated session switch",
+			token:  "secret",
+			method: http.MethodPost,
+			path:   "/client-sessions/active?clientID=did&sessionID=session",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "session switch with a wrong token",
+			token:  "secret",
+			method: http.MethodPost,
+			path:   "/client-sessions/active?clientID=did&sessionID=session",
+			header: "Bearer other",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "unauthenticated session list",
+			token:  "secret",
+			method: http.MethodGet,
+			path:   "/client-sessions?clientID=did",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "admin endpoints disabled",
+			method: http.MethodPost,
+			path:   "/client-sessions/active?clientID=did&sessionID=session",
+			header: "Bearer ",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "admin session switch",
+			token:  "secret",
+			method: http.MethodPost,
+			path:   "/client-sessions/active?clientID=did",
+			header: "Bearer secret",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ADMIN_TOKEN", tt.token)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("%v %v status = %d, want %d, body: %s", tt.method, tt.path, w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
@@ -13,6 +13,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/joho/godotenv/autoload"
+
+	"dapp/host/nft"
 )
 
 func getResult(c *gin.Context, artifactPath string, metadataPath string) {
@@ -202,29 +204,9 @@ func (s *Server) handleUploadAsset_GetArtifactInfo(c *gin.Context) {
 		return
 	}
 
-	rubixNftDir := os.Getenv("RUBIX_NFT_DIR")
-	if rubixNftDir == "" {
-		getInternalError(c, "RUBIX_NFT_DIR environment variable not set")
-		return
-	}
-
-	assetMetadataDir := path.Join(rubixNftDir, assetCID, "metadata.json")
-
-	assetMetadataObj, err := os.ReadFile(assetMetadataDir)
+	intf, err := nft.ReadLocalMetadata(assetCID)
 	if err != nil {
-		getInternalError(c, fmt.Sprintf("failed to read asset metadata file: %v", err))
-		return
-	}
-
-	if len(assetMetadataObj) == 0 {
-		getInternalError(c, fmt.Sprintf("metadata file for NFT ID %v is empty", assetCID))
-		return
-	}
-
-	var intf map[string]interface{}
-
-	if err := json.Unmarshal(assetMetadataObj, &intf); err != nil {
-		getInternalError(c, fmt.Sprintf("unable to unmarshal metadata JSON: %v", err))
+		getInternalError(c, err.Error())
 		return
 	}
 
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/sha256"
+	"dapp/host/onboarding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	leveldbUtil "github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const CALLBACK_KEY_PREFIX = "callback:"
+
+// CALLBACK_ALIAS_PREFIX keys the digest of a processed callback to the key it
+// was processed under
+const CALLBACK_ALIAS_PREFIX = "callback-alias:"
+
+// authenticateCallback confirms that a callback reports a block of its smart
+// contract, and returns that block. The block must carry the contract data of
+// the callback, and must have been executed and signed by the initiator DID.
+// The whole token chain is searched, so that a callback delivered or retried
+// after later blocks were added is still matched to its own block.
+func authenticateCallback(contractInputRequest *ContractInputRequest) (*onboarding.SCTDataReply, error) {
+	blocks, err := onboarding.GetSmartContractChain(RUBIX_API, contractInputRequest.SmartContractHash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch the blocks of smart contract %v, err: %v", contractInputRequest.SmartContractHash, err)
+	}
+
+	candidates := callbackBlocks(blocks, contractInputRequest)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no block of smart contract %v carries smart_contract_data executed by %v", contractInputRequest.SmartContractHash, contractInputRequest.InitiatorDID)
+	}
+
+	for _, block := range candidates {
+		if err = verifyDIDSignature(block.ExecutorDID, block.InitiatorSignData, block.InitiatorSignature); err == nil {
+			return &block, nil
+		}
+	}
+
+	return nil, fmt.Errorf("the initiator signature of block %v is invalid, err: %v", candidates[len(candidates)-1].BlockId, err)
+}
+
+// callbackBlocks returns the blocks whose contract data and executor are the
+// ones of the callback, latest first
+func callbackBlocks(blocks []onboarding.SCTDataReply, contractInputRequest *ContractInputRequest) []onboarding.SCTDataReply {
+	var matches []onboarding.SCTDataReply
+	for _, block := range blocks {
+		if block.SmartContractData == contractInputRequest.SmartContractData && block.ExecutorDID == contractInputRequest.InitiatorDID {
+			matches = append(matches, block)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].BlockNo > matches[j].BlockNo
+	})
+	return matches
+}
+
+// verifyDIDSignature checks that signature is a hex encoded signature of
+// message made with the key of did, whose public key is read from the DID
+// directory of the Rubix node
+func verifyDIDSignature(did string, message string, signature string) error {
+	if did == "" || strings.ContainsAny(did, "/\\.") {
+		return fmt.Errorf("invalid DID %q", did)
+	}
+
+	pubKeyPath := path.Join(onboarding.DID_DIR, did, "pubKey.pem")
+	pubKey, err := onboarding.GetPubKeyFromFile(pubKeyPath, did)
+	if err != nil {
+		return fmt.Errorf("failed to load the public key of %v, err: %v", did, err)
+	}
+
+	isSignatureValid, err := onboarding.VerifyPlatformSignature(message, pubKey, signature)
+	if err != nil {
+		return fmt.Errorf("failed to verify the signature of %v, err: %v", did, err)
+	}
+	if !isSignatureValid {
+		return fmt.Errorf("signature does not match the key of %v", did)
+	}
+
+	return nil
+}
+
+// callbackKey derives the idempotency key of a callback from the block it
+// reports. Callbacks without a block ID fall back to a digest of the contract,
+// its data and the initiator.
+func callbackKey(name string, contractInputRequest *ContractInputRequest, block *onboarding.SCTDataReply) string {
+	if block != nil && block.BlockId != "" {
+		return "block:" + block.BlockId
+	}
+
+	digest := sha256.New()
+	for _, field := range []string{name, contractInputRequest.SmartContractHash, contractInputRequest.SmartContractData, contractInputRequest.InitiatorDID} {
+		digest.Write([]byte(field))
+		digest.Write([]byte{0})
+	}
+	return "digest:" + hex.EncodeToString(digest.Sum(nil))
+}
+
+// CallbackIndex keeps the callbacks processed by the dapp, along with the job
+// each of them started, so that a retried or replayed callback is answered
+// with the outcome of the original one instead of being executed again
+type CallbackIndex struct {
+	db *leveldb.DB
+	mu sync.Mutex
+}
+
+func NewCallbackIndex(db *leveldb.DB) *CallbackIndex {
+	return &CallbackIndex{db: db}
+}
+
+func (i *CallbackIndex) get(key string) (*Job, error) {
+	jobBytes, err := i.db.Get([]byte(CALLBACK_KEY_PREFIX+key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var job *Job
+	if err := json.Unmarshal(jobBytes, &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal callback %s: %v", key, err)
+	}
+
+	return job, nil
+}
+
+// Put records the latest state of the job started by the callback key
+func (i *CallbackIndex) Put(key string, job Job) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.put(key, job)
+}
+
+func (i *CallbackIndex) put(key string, job Job) error {
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job of callback %s: %v", key, err)
+	}
+
+	if err := i.db.Put([]byte(CALLBACK_KEY_PREFIX+key), jobBytes, nil); err != nil {
+		return fmt.Errorf("failed to store callback %s: %v", key, err)
+	}
+
+	return nil
+}
+
+// Forget drops the callback key, so that a retry of the callback is
+// executed as if it were the first
+func (i *CallbackIndex) Forget(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.db.Delete([]byte(CALLBACK_KEY_PREFIX+key), nil); err != nil {
+		return fmt.Errorf("failed to forget callback %s: %v", key, err)
+	}
+
+	return nil
+}
+
+// Link records that the callback whose digest is alias was processed under
+// key, so that its outcome can be found by Finished
+func (i *CallbackIndex) Link(alias string, key string) error {
+	if err := i.db.Put([]byte(CALLBACK_ALIAS_PREFIX+alias), []byte(key), nil); err != nil {
+		return fmt.Errorf("failed to link callback %s to %s: %v", alias, key, err)
+	}
+
+	return nil
+}
+
+// Finished returns the job of the callback linked to alias, if it has
+// finished
+func (i *CallbackIndex) Finished(alias string) (*Job, bool) {
+	key, err := i.db.Get([]byte(CALLBACK_ALIAS_PREFIX+alias), nil)
+	if err != nil {
+		return nil, false
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	job, err := i.get(string(key))
+	if err != nil || !job.State.finished() {
+		return nil, false
+	}
+	return job, true
+}
+
+// Recover forgets the callbacks whose job was queued or running when the
+// dapp last stopped, so that a retry of them is executed instead of being
+// answered with a job which will never finish. It must be called before any
+// job is submitted, and returns the number of callbacks forgotten.
+func (i *CallbackIndex) Recover() (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	iter := i.db.NewIterator(leveldbUtil.BytesPrefix([]byte(CALLBACK_KEY_PREFIX)), nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		var job *Job
+		if err := json.Unmarshal(iter.Value(), &job); err == nil && job.State.finished() {
+			continue
+		}
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("failed to iterate callbacks: %v", err)
+	}
+
+	if err := i.db.Write(batch, nil); err != nil {
+		return 0, fmt.Errorf("failed to forget unfinished callbacks: %v", err)
+	}
+
+	return batch.Len(), nil
+}
+
+// Process calls submit for a callback seen for the first time. For a
+// callback processed before, the job it started is returned along with
+// duplicate set to true.
+func (i *CallbackIndex) Process(key string, submit func() (*Job, error)) (job *Job, duplicate bool, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	job, err = i.get(key)
+	if err == nil {
+		return job, true, nil
+	}
+	if err != leveldb.ErrNotFound {
+		return nil, false, err
+	}
+
+	job, err = submit()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The lock is held until the queued job is recorded, so that its
+	// outcome cannot be overwritten by this earlier state
+	if err := i.put(key, *job); err != nil {
+		fmt.Println(err)
+	}
+
+	return job, false, nil
+}
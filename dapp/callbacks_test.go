@@ -0,0 +1,211 @@
+package main
+
+import (
+	"dapp/host/onboarding"
+	"errors"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newTestDB(t *testing.T) *leveldb.DB {
+	t.Helper()
+
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestCallbackIndexProcess(t *testing.T) {
+	index := NewCallbackIndex(newTestDB(t))
+
+	submitted := 0
+	submit := func() (*Job, error) {
+		submitted++
+		return &Job{ID: "job", State: JobQueued}, nil
+	}
+
+	tests := []struct {
+		name string
+		// before updates the index ahead of the callback
+		before    func(t *testing.T)
+		key       string
+		submit    func() (*Job, error)
+		duplicate bool
+		state     JobState
+		err       bool
+		submitted int
+	}{
+		{name: "first callback is submitted", key: "block", submit: submit, state: JobQueued, submitted: 1},
+		{name: "replay is answered with the job", key: "block", submit: submit, duplicate: true, state: JobQueued, submitted: 1},
+		{
+			name: "replay is answered with the outcome",
+			before: func(t *testing.T) {
+				if err := index.Put("block", Job{ID: "job", State: JobSucceeded, Result: "done"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			key: "block", submit: submit, duplicate: true, state: JobSucceeded, submitted: 1,
+		},
+		{name: "another callback is submitted", key: "other", submit: submit, state: JobQueued, submitted: 2},
+		{
+			name:      "failed submission is not recorded",
+			key:       "refused",
+			submit:    func() (*Job, error) { return nil, ErrJobQueueFull },
+			err:       true,
+			submitted: 2,
+		},
+		{name: "refused callback is submitted on retry", key: "refused", submit: submit, state: JobQueued, submitted: 3},
+		{
+			name: "forgotten callback is submitted again",
+			before: func(t *testing.T) {
+				if err := index.Forget("other"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			key: "other", submit: submit, state: JobQueued, submitted: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.before != nil {
+				tt.before(t)
+			}
+
+			job, duplicate, err := index.Process(tt.key, tt.submit)
+			if (err != nil) != tt.err {
+				t.Fatalf("Process(%v) error = %v, want error %v", tt.key, err, tt.err)
+			}
+			if err == nil && (duplicate != tt.duplicate || job.State != tt.state) {
+				t.Fatalf("Process(%v) = %v (duplicate %v), want %v (duplicate %v)", tt.key, job.State, duplicate, tt.state, tt.duplicate)
+			}
+			if submitted != tt.submitted {
+				t.Fatalf("%d jobs submitted, want %d", submitted, tt.submitted)
+			}
+		})
+	}
+}
+
+func TestCallbackIndexRecover(t *testing.T) {
+	db := newTestDB(t)
+	index := NewCallbackIndex(db)
+
+	states := map[string]JobState{
+		"queued":          JobQueued,
+		"running":         JobRunning,
+		"awaiting_wallet": JobAwaitingWallet,
+		"succeeded":       JobSucceeded,
+		"failed":          JobFailed,
+		"timed_out":       JobTimedOut,
+		"out_of_fuel":     JobOutOfFuel,
+		"out_of_memory":   JobOutOfMemory,
+	}
+	for key, state := range states {
+		if err := index.Put(key, Job{ID: key, State: state}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put([]byte(CALLBACK_KEY_PREFIX+"corrupt"), []byte("{"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Keys outside of the index are left alone
+	if err := db.Put([]byte("job:queued"), []byte("{}"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	forgotten, err := index.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forgotten != 4 {
+		t.Fatalf("Recover() forgot %d callbacks, want 4", forgotten)
+	}
+
+	for key, state := range states {
+		_, duplicate, err := index.Process(key, func() (*Job, error) {
+			return nil, errors.New("submitted")
+		})
+		if state.finished() != duplicate {
+			t.Fatalf("callback of a %v job: duplicate = %v, err: %v", state, duplicate, err)
+		}
+	}
+	if has, _ := db.Has([]byte("job:queued"), nil); !has {
+		t.Fatal("Recover() deleted a key outside of the callback index")
+	}
+}
+
+func TestCallbackIndexFinished(t *testing.T) {
+	index := NewCallbackIndex(newTestDB(t))
+
+	for key, state := range map[string]JobState{"done": JobSucceeded, "pending": JobRunning} {
+		if err := index.Put(key, Job{ID: key, State: state}); err != nil {
+			t.Fatal(err)
+		}
+		if err := index.Link("digest:"+key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Link("digest:forgotten", "forgotten"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		alias    string
+		finished bool
+	}{
+		{alias: "digest:done", finished: true},
+		{alias: "digest:pending", finished: false},
+		{alias: "digest:forgotten", finished: false},
+		{alias: "digest:unknown", finished: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			job, finished := index.Finished(tt.alias)
+			if finished != tt.finished {
+				t.Fatalf("Finished(%v) = %v, want %v", tt.alias, finished, tt.finished)
+			}
+			if finished && job.State != JobSucceeded {
+				t.Fatalf("Finished(%v) state = %v, want %v", tt.alias, job.State, JobSucceeded)
+			}
+		})
+	}
+}
+
+func TestCallbackBlocks(t *testing.T) {
+	blocks := []onboarding.SCTDataReply{
+		{BlockNo: 1, BlockId: "first", SmartContractData: "data", ExecutorDID: "did"},
+		{BlockNo: 2, BlockId: "other data", SmartContractData: "other", ExecutorDID: "did"},
+		{BlockNo: 3, BlockId: "other executor", SmartContractData: "data", ExecutorDID: "other"},
+		{BlockNo: 4, BlockId: "latest", SmartContractData: "data", ExecutorDID: "did"},
+	}
+
+	tests := []struct {
+		name    string
+		request ContractInputRequest
+		want    []string
+	}{
+		{name: "matching blocks, latest first", request: ContractInputRequest{SmartContractData: "data", InitiatorDID: "did"}, want: []string{"latest", "first"}},
+		{name: "block before the latest", request: ContractInputRequest{SmartContractData: "other", InitiatorDID: "did"}, want: []string{"other data"}},
+		{name: "data executed by another DID", request: ContractInputRequest{SmartContractData: "other", InitiatorDID: "other"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := callbackBlocks(blocks, &tt.request)
+			if len(matches) != len(tt.want) {
+				t.Fatalf("callbackBlocks() = %v, want %v", matches, tt.want)
+			}
+			for i, block := range matches {
+				if block.BlockId != tt.want[i] {
+					t.Fatalf("callbackBlocks()[%d] = %v, want %v", i, block.BlockId, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"dapp/events"
+	"dapp/host/credits"
+	"dapp/host/ft"
+	"dapp/host/nft"
+	"dapp/host/onboarding"
+	"dapp/wallet"
+	"dapp/wasmcache"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
+
+	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
+	wasmContext "github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
+)
+
+const ARTIFACTS_DIR = "../artifacts"
+const ARTIFACTS_POLL_INTERVAL = 2 * time.Second
+const QUORUM_TYPE = 2
+
+// Limits applied to contracts whose spec does not set its own. They can be
+// overridden with the CONTRACT_TIMEOUT (for instance "90s"), CONTRACT_FUEL,
+// CONTRACT_MAX_MEMORY (in bytes) and CONTRACT_MAX_TABLE_ELEMENTS environment
+// variables.
+const DEFAULT_CONTRACT_TIMEOUT = 5 * time.Minute
+const DEFAULT_CONTRACT_FUEL = 1_000_000_000
+const DEFAULT_CONTRACT_MAX_MEMORY = 64 << 20
+const DEFAULT_CONTRACT_MAX_TABLE_ELEMENTS = 10_000
+
+// Price of a credit when CREDIT_FT_NAME or CREDIT_PRICE are not set
+const DEFAULT_CREDIT_FT_NAME = "TRIE"
+const DEFAULT_CREDIT_PRICE = 1
+
+// ContractSpec describes how the dapp executes a contract: the wasm artifact
+// backing it, the host functions it is allowed to import, whether it needs
+// the initiator's wallet socket and how its output is turned into a reply.
+type ContractSpec struct {
+	WasmFile       string
+	HostFunctions  func(x *Execution) []host.HostFunction
+	RequiresWallet bool
+
+	// HandleResult post-processes the contract output into the message sent
+	// back to the caller. The raw output is returned when it is nil.
+	HandleResult func(s *Server, x *Execution, result string) (string, error)
+
+	// Timeout bounds the whole execution, time spent waiting on the wallet
+	// included, and Fuel bounds the instructions run by the contract.
+	// MaxMemory bounds the linear memory of the contract in bytes, and
+	// MaxTableElements the size of its tables. The defaults apply when they
+	// are zero.
+	Timeout          time.Duration
+	Fuel             uint64
+	MaxMemory        uint64
+	MaxTableElements uint32
+}
+
+func (spec *ContractSpec) timeout() time.Duration {
+	if spec.Timeout > 0 {
+		return spec.Timeout
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("CONTRACT_TIMEOUT")); err == nil && timeout > 0 {
+		return timeout
+	}
+	return DEFAULT_CONTRACT_TIMEOUT
+}
+
+func (spec *ContractSpec) fuel() uint64 {
+	if spec.Fuel > 0 {
+		return spec.Fuel
+	}
+	if fuel, err := strconv.ParseUint(os.Getenv("CONTRACT_FUEL"), 10, 64); err == nil && fuel > 0 {
+		return fuel
+	}
+	return DEFAULT_CONTRACT_FUEL
+}
+
+func (spec *ContractSpec) limits() wasmcache.Limits {
+	limits := wasmcache.Limits{
+		MaxMemory:        spec.MaxMemory,
+		MaxTableElements: spec.MaxTableElements,
+	}
+
+	if limits.MaxMemory == 0 {
+		limits.MaxMemory = DEFAULT_CONTRACT_MAX_MEMORY
+		if maxMemory, err := strconv.ParseUint(os.Getenv("CONTRACT_MAX_MEMORY"), 10, 64); err == nil && maxMemory > 0 {
+			limits.MaxMemory = maxMemory
+		}
+	}
+	if limits.MaxTableElements == 0 {
+		limits.MaxTableElements = DEFAULT_CONTRACT_MAX_TABLE_ELEMENTS
+		if maxElements, err := strconv.ParseUint(os.Getenv("CONTRACT_MAX_TABLE_ELEMENTS"), 10, 32); err == nil && maxElements > 0 {
+			limits.MaxTableElements = uint32(maxElements)
+		}
+	}
+
+	return limits
+}
+
+var contractRegistry = map[string]*ContractSpec{
+	"upload_asset": {
+		WasmFile: "asset_publish_contract.wasm",
+		HostFunctions: func(x *Execution) []host.HostFunction {
+			return []host.HostFunction{
+				ft.NewDoTransferFTApiCall(x.Signer, x.Events, x.Payments),
+				nft.NewDoMintNFTApiCall(x.Signer, x.Events),
+				ft.NewDoCreateFTApiCall(x.Signer),
+				ft.NewDoGetFTBalance(),
+			}
+		},
+		RequiresWallet: true,
+	},
+	"pay_for_inference": {
+		WasmFile: "inference_contract.wasm",
+		HostFunctions: func(x *Execution) []host.HostFunction {
+			return []host.HostFunction{
+				ft.NewDoTransferFTApiCall(x.Signer, x.Events, x.Payments),
+				nft.NewDoExecuteNFT(x.Signer),
+				ft.NewDoGetFTBalance(),
+				credits.NewDoGetCreditBalance(x.Credits),
+				credits.NewDoDeductCredit(x.Credits, x.Record.InitiatorDID, x.addCreditDeduction),
+				nft.NewDoReadAssetMetadata(),
+			}
+		},
+		RequiresWallet: true,
+	},
+	"use_asset": {
+		WasmFile: "asset_usage_contract.wasm",
+		HostFunctions: func(x *Execution) []host.HostFunction {
+			return []host.HostFunction{
+				ft.NewDoTransferFTApiCall(x.Signer, x.Events, x.Payments),
+				nft.NewDoExecuteNFT(x.Signer),
+				ft.NewDoCreateFTApiCall(x.Signer),
+				ft.NewDoGetFTBalance(),
+				credits.NewDoGetCreditBalance(x.Credits),
+				credits.NewDoDeductCredit(x.Credits, x.Record.InitiatorDID, x.addCreditDeduction),
+				nft.NewDoReadAssetMetadata(),
+			}
+		},
+		RequiresWallet: true,
+	},
+	"transfer_asset": {
+		WasmFile: "asset_transfer_contract.wasm",
+		HostFunctions: func(x *Execution) []host.HostFunction {
+			return []host.HostFunction{
+				nft.NewDoTransferNFT(x.Signer, x.Events),
+			}
+		},
+		RequiresWallet: true,
+	},
+	"create_token": {
+		WasmFile: "asset_create_ft.wasm",
+		HostFunctions: func(x *Execution) []host.HostFunction {
+			return []host.HostFunction{
+				ft.NewDoCreateFTApiCall(x.Signer),
+			}
+		},
+		RequiresWallet: true,
+	},
+	"onboard_infra_provider": {
+		WasmFile: "onboarding_contract.wasm",
+		HostFunctions: func(x *Execution) []host.HostFunction {
+			return []host.HostFunction{
+				onboarding.NewVerifyAction(x.Events, x.Simulated),
+			}
+		},
+		HandleResult: handleOnboardingResult,
+		Timeout:      time.Minute,
+	},
+	"add_credits": {
+		WasmFile: "inference_credit_purchase_contract.wasm",
+		HostFunctions: func(x *Execution) []host.HostFunction {
+			return []host.HostFunction{
+				ft.NewDoTransferFTApiCall(x.Signer, x.Events, x.Payments),
+				credits.NewDoAddCredit(x.Credits, x.Payments, x.CreditPrice, x.addCreditGrant),
+				ft.NewDoGetFTBalance(),
+				credits.NewDoGetCreditBalance(x.Credits),
+			}
+		},
+		RequiresWallet: true,
+		HandleResult:   handleAddCreditsResult,
+	},
+}
+
+// contractHandler serves the callback route of a single registered contract
+func (s *Server) contractHandler(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.serveContractExecution(c, name)
+	}
+}
+
+func (s *Server) handleExecuteContract(c *gin.Context) {
+	s.serveContractExecution(c, c.Param("name"))
+}
+
+func (s *Server) serveContractExecution(c *gin.Context, name string) {
+	var contractInputRequest ContractInputRequest
+
+	err := json.NewDecoder(c.Request.Body).Decode(&contractInputRequest)
+	if err != nil {
+		wrapError(c.JSON, "err: Invalid request body")
+		return
+	}
+
+	spec, ok := contractRegistry[name]
+	if !ok {
+		wrapError(c.JSON, fmt.Sprintf("contract %s is not registered", name))
+		return
+	}
+
+	// Callbacks are rejected before being queued if they do not name a
+	// verified deployment of the contract, or do not match a block signed
+	// by the initiator
+	if err := s.verifyDeployment(name, spec, &contractInputRequest); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	}
+
+	// A retry of a callback which already ran is answered with its outcome,
+	// even once its block can no longer be matched
+	alias := callbackKey(name, &contractInputRequest, nil)
+	block, err := authenticateCallback(&contractInputRequest)
+	if err != nil {
+		if job, ok := s.Callbacks.Finished(alias); ok {
+			s.respondDuplicate(c, job)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	}
+
+	key := callbackKey(name, &contractInputRequest, block)
+	job, duplicate, err := s.Callbacks.Process(key, func() (*Job, error) {
+		return s.Jobs.Submit(name, &contractInputRequest, key)
+	})
+	if err != nil {
+		if err == ErrJobQueueFull || err == ErrShuttingDown {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+		wrapError(c.JSON, err.Error())
+		return
+	}
+
+	if duplicate {
+		s.respondDuplicate(c, job)
+		return
+	}
+
+	if alias != key {
+		if err := s.Callbacks.Link(alias, key); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"job_id": job.ID, "state": job.State})
+}
+
+// respondDuplicate answers a callback processed before with the job it
+// started
+func (s *Server) respondDuplicate(c *gin.Context, job *Job) {
+	// The job is still held by the queue until it is pruned, and is
+	// otherwise answered from the state recorded when it finished
+	if current, ok := s.Jobs.Get(job.ID); ok {
+		job = &current
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"job_id":       job.ID,
+		"state":        job.State,
+		"execution_id": job.ExecutionID,
+		"result":       job.Result,
+		"error":        job.Error,
+		"duplicate":    true,
+	})
+}
+
+// executeContract runs the registered contract name and records the run in
+// the execution journal. The returned record is nil only when name is not
+// a registered contract.
+func (s *Server) executeContract(ctx context.Context, name string, contractInputRequest *ContractInputRequest, opts ...ExecutionOption) (*ExecutionRecord, error) {
+	spec, ok := contractRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("contract %s is not registered", name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, spec.timeout())
+	defer cancel()
+
+	exec := s.Journal.Begin(ctx, name, contractInputRequest, opts...)
+
+	result, err := s.runContract(spec, exec, contractInputRequest)
+	s.settleCreditDeductions(exec, err)
+	exec.Finish(result, err)
+
+	return exec.Record, err
+}
+
+func (s *Server) runContract(spec *ContractSpec, exec *Execution, contractInputRequest *ContractInputRequest) (string, error) {
+	if contractInputRequest.SmartContractData == "" {
+		return "", fmt.Errorf("unable to fetch Smart Contract from callback")
+	}
+
+	// The artifact is verified again, as it may have been reloaded since
+	// the callback was accepted. Dry runs are not tied to a deployment.
+	if !exec.Simulated {
+		if err := s.verifyDeployment(exec.Record.Contract, spec, contractInputRequest); err != nil {
+			return "", err
+		}
+	}
+
+	wasmCtx := wasmContext.NewWasmContext()
+	if spec.RequiresWallet && !exec.Simulated {
+		signer, err := s.signerFor(contractInputRequest.InitiatorDID)
+		if err != nil {
+			return "", err
+		}
+		exec.UseSigner(signer)
+	}
+	if !exec.Simulated {
+		exec.Events = EventBus
+	}
+	exec.Credits = s.Credits
+	exec.CreditPrice = s.CreditPrice
+	if exec.Simulated {
+		exec.Credits = s.Credits.DryRun()
+	}
+
+	// Create Import function registry
+	hostFnRegistry := wasmbridge.NewHostFunctionRegistry()
+	for _, hostFn := range exec.WrapHostFunctions(spec.HostFunctions(exec)) {
+		hostFnRegistry.Register(hostFn)
+	}
+
+	// Instantiate the precompiled WASM module
+	wasmModule, err := s.Modules.NewInstance(
+		spec.WasmFile,
+		hostFnRegistry,
+		wasmcache.WithRubixNodeAddress(RUBIX_API),
+		wasmcache.WithQuorumType(QUORUM_TYPE),
+		wasmcache.WithWasmContext(wasmCtx),
+		wasmcache.WithFuel(spec.fuel()),
+		wasmcache.WithLimits(spec.limits()),
+	)
+	if err != nil {
+		return "", fmt.Errorf("unable to initialize wasmModule: %v", err)
+	}
+
+	output, err := wasmModule.CallFunction(exec.ctx, contractInputRequest.SmartContractData)
+	if err != nil {
+		return "", fmt.Errorf("unable to execute function, err: %w", err)
+	}
+	exec.SetOutput(output)
+
+	// Result handlers may have side effects, such as crediting the initiator,
+	// so a dry run reports the raw output of the contract
+	if spec.HandleResult != nil && !exec.Simulated {
+		return spec.HandleResult(s, exec, output)
+	}
+
+	return output, nil
+}
+
+// signerFor returns the signer of the wallet commands of did. Commands are
+// sent through the broker of the active session of did, which serializes
+// them with those of the other executions using it. The commands of the DID
+// managed by the node signer are signed by the node when none of its
+// wallets is connected, so that automated flows run without a browser.
+func (s *Server) signerFor(did string) (wallet.Signer, error) {
+	headless := s.NodeSigner != nil && s.NodeSigner.DID() == did
+
+	session, ok := TrieClients.Get(did)
+	if ok && (!headless || session.Broker.Connected()) {
+		return session.Broker, nil
+	}
+	if headless {
+		fmt.Printf("no wallet of %v is connected, signing with the node\n", did)
+		return s.NodeSigner, nil
+	}
+
+	return nil, fmt.Errorf("clientID %s not found", did)
+}
+
+// nodeSignerFromEnv configures the node signer from the NODE_SIGNER_DID and
+// NODE_SIGNER_PASSWORD environment variables. There is no node signer when
+// NODE_SIGNER_DID is not set.
+func nodeSignerFromEnv() *wallet.NodeSigner {
+	did := os.Getenv("NODE_SIGNER_DID")
+	if did == "" {
+		return nil
+	}
+
+	fmt.Printf("commands of %v are signed by the node when its wallet is not connected\n", did)
+	return wallet.NewNodeSigner(RUBIX_API, did, os.Getenv("NODE_SIGNER_PASSWORD"))
+}
+
+// creditPriceFromEnv reads the price of a credit from the environment:
+// CREDIT_PRICE FTs (default 1) named CREDIT_FT_NAME (default TRIE), minted by
+// CREDIT_FT_CREATOR_DID if set, paid to CREDIT_RECEIVER_DID. Credits are not
+// sold when CREDIT_RECEIVER_DID is not set.
+func creditPriceFromEnv() credits.Price {
+	price := credits.Price{
+		Receiver:  os.Getenv("CREDIT_RECEIVER_DID"),
+		FTName:    DEFAULT_CREDIT_FT_NAME,
+		FTCreator: os.Getenv("CREDIT_FT_CREATOR_DID"),
+		FTCount:   DEFAULT_CREDIT_PRICE,
+	}
+	if ftName := os.Getenv("CREDIT_FT_NAME"); ftName != "" {
+		price.FTName = ftName
+	}
+	if ftCount, err := strconv.ParseInt(os.Getenv("CREDIT_PRICE"), 10, 32); err == nil && ftCount > 0 {
+		price.FTCount = int32(ftCount)
+	}
+
+	if price.Receiver == "" {
+		fmt.Println("credits are not sold, CREDIT_RECEIVER_DID is not set")
+	}
+	return price
+}
+
+// verifyDeployment checks that the callback names a deployment of the
+// contract name, built from the local artifact of the contract
+func (s *Server) verifyDeployment(name string, spec *ContractSpec, contractInputRequest *ContractInputRequest) error {
+	localDigest, err := s.Modules.Digest(spec.WasmFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the artifact of %v: %v", name, err)
+	}
+
+	return s.Deployments.Verify(name, contractInputRequest.SmartContractHash, localDigest)
+}
+
+func handleOnboardingResult(s *Server, x *Execution, contractResult string) (string, error) {
+	msg, errMsg := extractSignatureVerificationOutput(contractResult)
+	if errMsg != "" {
+		return "", fmt.Errorf("error occured while verifying the signature, err: %v", errMsg)
+	}
+
+	switch msg {
+	case "Success":
+		return "signature is valid", nil
+	case "Fail":
+		return "signature is invalid", nil
+	default:
+		return "", fmt.Errorf("unexpected error occured while retrieving the signature verification result, msg val extracted: %v", msg)
+	}
+}
+
+// handleAddCreditsResult reports the credits added by do_add_credit. The
+// contract output is not relied upon, as the credits were already written
+// by the host function against the payment of the execution.
+func handleAddCreditsResult(s *Server, x *Execution, contractResult string) (string, error) {
+	grants := x.CreditGrants()
+	if len(grants) == 0 {
+		return "", fmt.Errorf("no credits were added, the contract did not pay for any")
+	}
+
+	messages := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		publishCreditBalance(s.Credits, events.TopicCreditsAdded, grant.UserDid, grant.Credit)
+		messages = append(messages, fmt.Sprintf("Successfully added %d credits to DID %s for payment %s", grant.Credit, grant.UserDid, grant.TxID))
+	}
+
+	return strings.Join(messages, "; "), nil
+}
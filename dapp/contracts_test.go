@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"dapp/events"
+	"dapp/host/nft"
+	"dapp/wasmcache"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
+)
+
+// TestContractRegistry checks that every registered contract is backed by an
+// artifact which compiles, and offers each host function once
+func TestContractRegistry(t *testing.T) {
+	modules, err := wasmcache.New(ARTIFACTS_DIR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer modules.Close()
+
+	for name, spec := range contractRegistry {
+		t.Run(name, func(t *testing.T) {
+			if _, err := modules.Digest(spec.WasmFile); err != nil {
+				t.Fatalf("artifact %v of %v: %v", spec.WasmFile, name, err)
+			}
+
+			x := newExecution(context.Background(), name, &ContractInputRequest{InitiatorDID: "did"})
+			names := make(map[string]bool)
+			for _, hostFn := range spec.HostFunctions(x) {
+				if names[hostFn.Name()] {
+					t.Fatalf("host function %v is offered twice", hostFn.Name())
+				}
+				names[hostFn.Name()] = true
+			}
+		})
+	}
+}
+
+func TestContractLookup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := &Server{}
+
+	tests := []struct {
+		name    string
+		path    string
+		status  int
+		message string
+	}{
+		{
+			name:    "unknown contract",
+			path:    "/api/contracts/unknown/execute",
+			status:  http.StatusNotFound,
+			message: "contract unknown is not registered",
+		},
+		{
+			name:    "unknown contract simulated",
+			path:    "/api/contracts/unknown/simulate",
+			status:  http.StatusNotFound,
+			message: "contract unknown is not registered",
+		},
+	}
+
+	r := gin.New()
+	r.POST("/api/contracts/:name/execute", server.handleExecuteContract)
+	r.POST("/api/contracts/:name/simulate", server.handleSimulateContract)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"smart_contract_hash": "hash", "smart_contract_data": "{}", "initiator_did": "did"}`))
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.message) {
+				t.Fatalf("%v = %d %s, want %d %q", tt.path, w.Code, w.Body.String(), tt.status, tt.message)
+			}
+		})
+	}
+
+	if _, err := server.executeContract(context.Background(), "unknown", &ContractInputRequest{}); err == nil {
+		t.Fatal("executeContract() ran an unknown contract")
+	}
+}
+
+// testSigner answers every wallet command with reply
+type testSigner struct {
+	reply   string
+	actions []string
+}
+
+func (s *testSigner) Send(action string, payload interface{}) ([]byte, error) {
+	s.actions = append(s.actions, action)
+	return []byte(s.reply), nil
+}
+
+// TestTransferAssetContract runs the shipped transfer_asset artifact against
+// the do_transfer_nft host function
+func TestTransferAssetContract(t *testing.T) {
+	spec := contractRegistry["transfer_asset"]
+
+	dir := t.TempDir()
+	wasmBytes, err := os.ReadFile(path.Join(ARTIFACTS_DIR, spec.WasmFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, spec.WasmFile), wasmBytes, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modules, err := wasmcache.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer modules.Close()
+
+	tests := []struct {
+		name     string
+		receiver string
+		reply    string
+		output   string
+		err      bool
+		commands int
+	}{
+		{
+			name:     "approved by the owner",
+			receiver: "buyer",
+			reply:    `{"status": true, "message": "NFT transferred", "tx_id": "tx"}`,
+			output:   `{"nftId":"nft","txId":"tx"}`,
+			commands: 1,
+		},
+		{
+			name:     "rejected by the owner",
+			receiver: "buyer",
+			reply:    `{"status": false, "message": "rejected"}`,
+			err:      true,
+			commands: 1,
+		},
+		{
+			name:     "transfer to the owner",
+			receiver: "owner",
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := &testSigner{reply: tt.reply}
+			registry := wasmbridge.NewHostFunctionRegistry()
+			registry.Register(nft.NewDoTransferNFT(signer, events.Discard))
+
+			instance, err := modules.NewInstance(spec.WasmFile, registry, wasmcache.WithQuorumType(QUORUM_TYPE))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			input, _ := json.Marshal(map[string]interface{}{
+				"transfer_asset": nft.TransferNFTReq{NFT: "nft", Owner: "owner", Receiver: tt.receiver, NFTValue: 1, Comment: "sold"},
+			})
+			output, err := instance.CallFunction(context.Background(), string(input))
+			if (err != nil) != tt.err || output != tt.output {
+				t.Fatalf("CallFunction() = %q, err: %v, want %q (error %v)", output, err, tt.output, tt.err)
+			}
+			if len(signer.actions) != tt.commands {
+				t.Fatalf("wallet received %v, want %d commands", signer.actions, tt.commands)
+			}
+		})
+	}
+}
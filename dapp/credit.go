@@ -1,27 +1,16 @@
 package main
 
 import (
-	"dapp/host/ft"
+	"dapp/events"
+	"dapp/host/credits"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"path"
-	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
-	wasmContext "github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
-	"github.com/syndtr/goleveldb/leveldb"
-
-	"dapp/host/credits"
 )
 
-type CreditInfo struct {
-	Credit    uint   `json:"credit"`
-	Timestamp string `json:"timestamp"`
-}
-
 func (s *Server) handleGetCreditBalance(c *gin.Context) {
 	w := http.ResponseWriter(c.Writer)
 	enableCors(&w)
@@ -32,13 +21,12 @@ func (s *Server) handleGetCreditBalance(c *gin.Context) {
 		return
 	}
 
-	if s.DB == nil {
+	if s.Credits == nil {
 		getInternalError(c, "Database is not initialized")
 		return
 	}
 
-	// Assuming GetCreditBalance is a function that retrieves the credit balance for the given DID
-	balance, err := getCreditBalance(s.DB, did)
+	balance, err := s.Credits.Balance(did)
 	if err != nil {
 		getInternalError(c, "Failed to retrieve credit balance: "+err.Error())
 		return
@@ -47,104 +35,55 @@ func (s *Server) handleGetCreditBalance(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"did": did, "credit ": balance})
 }
 
-func getCreditBalance(db *leveldb.DB, did string) (*CreditInfo, error) {
-	creditInfoBytes, err := db.Get([]byte(did), nil)
-	if err != nil {
-		if err == leveldb.ErrNotFound {
-			return &CreditInfo{Credit: 0, Timestamp: ""}, nil
-		}
-		return nil, fmt.Errorf("failed to get credit balance for DID %s: %v", did, err)
-	}
-
-	var creditInfo *CreditInfo
-	err = json.Unmarshal(creditInfoBytes, &creditInfo)
+// publishCreditBalance tells the subscribers of did that credit credits were
+// added to or deducted from its balance, along with the new balance
+func publishCreditBalance(ledger *credits.Ledger, topic events.Topic, did string, credit uint) {
+	balance, err := ledger.Balance(did)
 	if err != nil {
-		return nil, err // Error unmarshaling the balance
-	}
-
-	return creditInfo, nil
-}
-
-type AddCredit struct {
-	UserDid string  `json:"user_did"`
-	Credit  float64 `json:"credit"`
-}
-
-func (s *Server) handleAddCredits(c *gin.Context) {
-	nodeAddress := "http://localhost:20007"
-	quorumType := 2
-
-	selfContractHashPath := path.Join("../artifacts/inference_credit_purchase_contract.wasm")
-
-	var contractInputRequest ContractInputRequest
-
-	err := json.NewDecoder(c.Request.Body).Decode(&contractInputRequest)
-	if err != nil {
-		wrapError(c.JSON, "err: Invalid request body")
-		return
-	}
-
-	trieConn, ok := TrieClientsMap[contractInputRequest.InitiatorDID]
-	if !ok {
-		wrapError(c.JSON, fmt.Sprintf("clientID %s not found", contractInputRequest.InitiatorDID))
-		return
-	}
-
-	wasmCtx := wasmContext.NewWasmContext().WithExternalSocketConn(trieConn)
-
-	// Create Import function registry
-	hostFnRegistry := wasmbridge.NewHostFunctionRegistry()
-	hostFnRegistry.Register(ft.NewDoTransferFTApiCall())
-	hostFnRegistry.Register(credits.NewDoAddCredit())
-
-	// Initialize the WASM module
-	wasmModule, err := wasmbridge.NewWasmModule(
-		selfContractHashPath,
-		hostFnRegistry,
-		wasmbridge.WithRubixNodeAddress(nodeAddress),
-		wasmbridge.WithQuorumType(quorumType),
-		wasmbridge.WithWasmContext(wasmCtx),
-	)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to initialize wasmModule: %v", err))
+		fmt.Printf("unable to publish %v event of %v, err: %v\n", topic, did, err)
 		return
 	}
 
-	if contractInputRequest.SmartContractData == "" {
-		wrapError(c.JSON, fmt.Sprintf("unable to fetch Smart Contract from callback"))
-		return
-	}
-
-	creditInfoStr, err := wasmModule.CallFunction(contractInputRequest.SmartContractData)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to execute function, err: %v", err))
-		return
-	}
-
-	var addCredit AddCredit
-	err = json.Unmarshal([]byte(creditInfoStr), &addCredit)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to unmarshal credit info, err: %v", err))
-		return
-	}
+	EventBus.Publish(topic, map[string]interface{}{
+		"did":     did,
+		"credit":  credit,
+		"balance": balance.Credit,
+	}, did)
+}
 
-	currTimestamp := strconv.FormatInt(time.Now().Unix(), 10)
+// settleCreditDeductions refunds the credits deducted by an execution which
+// failed, so that users are not charged for what they did not get, and
+// publishes the deductions of an execution which succeeded
+func (s *Server) settleCreditDeductions(x *Execution, err error) {
+	for _, deduction := range x.CreditDeductions() {
+		if err != nil {
+			if _, refundErr := s.Credits.Refund(deduction.UserDid, deduction.Amount); refundErr != nil {
+				fmt.Printf("unable to refund %d credits of %v after execution %v failed, err: %v\n", deduction.Amount, deduction.UserDid, x.Record.ID, refundErr)
+			}
+			continue
+		}
 
-	// Assuming AddCredits is a function that adds credits to the given DID
-	err = addCreditsToDB(s.DB, addCredit.UserDid, uint(addCredit.Credit), currTimestamp)
-	if err != nil {
-		getInternalError(c, "Failed to add credits: "+err.Error())
-		return
+		balance, balanceErr := s.Credits.Balance(deduction.UserDid)
+		if balanceErr != nil {
+			fmt.Printf("unable to publish %v event of %v, err: %v\n", events.TopicCreditsDeducted, deduction.UserDid, balanceErr)
+			continue
+		}
+		EventBus.Publish(events.TopicCreditsDeducted, map[string]interface{}{
+			"did":      deduction.UserDid,
+			"credit":   deduction.Amount,
+			"balance":  balance.Credit,
+			"reason":   deduction.Reason,
+			"asset_id": deduction.AssetID,
+		}, deduction.UserDid)
 	}
-
-	wrapSuccess(c.JSON, fmt.Sprintf("Successfully added %.2f credits to DID %s", addCredit.Credit, addCredit.UserDid))
-	return
 }
 
 type DeductCreditsReq struct {
 	DID string `json:"did"`
 }
 
+// handleDeductCredits takes a credit from a DID on operator request. It is
+// served behind requireAdmin, contracts charge credits with do_deduct_credit.
 func (s *Server) handleDeductCredits(c *gin.Context) {
 	w := http.ResponseWriter(c.Writer)
 	enableCors(&w)
@@ -160,61 +99,16 @@ func (s *Server) handleDeductCredits(c *gin.Context) {
 		return
 	}
 
-	err = deductCreditsFromDB(s.DB, deductCreditsReq.DID)
+	_, err = s.Credits.Deduct(deductCreditsReq.DID, 1)
 	if err != nil {
+		if errors.Is(err, credits.ErrInsufficientCredits) {
+			getClientError(c, err.Error())
+			return
+		}
 		getInternalError(c, "Failed to deduct credits: "+err.Error())
 		return
 	}
+	publishCreditBalance(s.Credits, events.TopicCreditsDeducted, deductCreditsReq.DID, 1)
 
 	wrapSuccess(c.JSON, fmt.Sprintf("Successfully deducted credits from DID %s", deductCreditsReq.DID))
 }
-
-func deductCreditsFromDB(db *leveldb.DB, did string) error {
-	getCreditBalance, err := getCreditBalance(db, did)
-	if err != nil {
-		return fmt.Errorf("failed to get existing credit balance for DID %s: %v", did, err)
-	}
-
-	getCreditBalance.Credit -= 1
-	getCreditBalance.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
-
-	creditInfoBytes, err := json.Marshal(getCreditBalance)
-	if err != nil {
-		return fmt.Errorf("failed to marshal credit info: %v", err)
-	}
-
-	err = db.Put([]byte(did), creditInfoBytes, nil)
-	if err != nil {
-		return fmt.Errorf("failed to deduct credits for DID %s: %v", did, err)
-	}
-
-	return nil
-}
-
-func addCreditsToDB(db *leveldb.DB, did string, creditCount uint, currTimestamp string) error {
-	getCreditBalance, err := getCreditBalance(db, did)
-	if err != nil {
-		return fmt.Errorf("failed to get existing credit balance for DID %s: %v", did, err)
-	}
-
-	if getCreditBalance.Credit == 0 {
-		getCreditBalance.Credit = creditCount
-		getCreditBalance.Timestamp = currTimestamp
-	} else {
-		getCreditBalance.Credit += creditCount
-		getCreditBalance.Timestamp = currTimestamp
-	}
-
-	// For example, you might want to update the existing credit balance or create a new entry.
-	creditInfoBytes, err := json.Marshal(getCreditBalance)
-	if err != nil {
-		return fmt.Errorf("failed to marshal credit info: %v", err)
-	}
-
-	err = db.Put([]byte(did), creditInfoBytes, nil)
-	if err != nil {
-		return fmt.Errorf("failed to add credits for DID %s: %v", did, err)
-	}
-
-	return nil
-}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"dapp/host/credits"
+	"errors"
+	"testing"
+)
+
+func TestSettleCreditDeductions(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		balance uint
+	}{
+		{name: "execution succeeded", balance: 7},
+		{name: "execution failed", err: errors.New("contract trapped"), balance: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Credits: credits.NewLedger(newTestDB(t))}
+			if err := s.Credits.AddForPayment("did", 10, "tx"); err != nil {
+				t.Fatal(err)
+			}
+
+			x := newExecution(context.Background(), "pay_for_inference", &ContractInputRequest{InitiatorDID: "did"})
+			for _, amount := range []uint{1, 2} {
+				if _, err := s.Credits.Deduct("did", amount); err != nil {
+					t.Fatal(err)
+				}
+				x.addCreditDeduction(credits.Deduction{UserDid: "did", Amount: amount, Reason: "inference"})
+			}
+
+			s.settleCreditDeductions(x, tt.err)
+
+			balance, err := s.Credits.Balance("did")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance.Credit != tt.balance {
+				t.Fatalf("balance = %d, want %d", balance.Credit, tt.balance)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+)
+
+// DEPLOYED_CONTRACTS_FILE maps the hash of every deployed smart contract to
+// the registered contract it was deployed from
+const DEPLOYED_CONTRACTS_FILE = "./deployed_contracts.json"
+
+// DEPLOYED_BINARY_FILE is the name under which the Rubix node stores the
+// binary of a smart contract it has fetched
+const DEPLOYED_BINARY_FILE = "binaryCodeFile.wasm"
+
+// ContractDeployment links a deployed smart contract hash to the registered
+// contract, whose local artifact is executed on its callbacks
+type ContractDeployment struct {
+	SmartContractHash string `json:"smart_contract_hash"`
+	Contract          string `json:"contract"`
+
+	registered     bool
+	deployedDigest string
+}
+
+// ContractDeployments verifies that callbacks name a known deployment of the
+// contract they are routed to, registered on chain and built from the same
+// binary as the local artifact
+type ContractDeployments struct {
+	mu          sync.Mutex
+	deployments map[string]*ContractDeployment
+}
+
+// LoadContractDeployments reads the deployments listed in file. A missing
+// file yields no deployments, in which case every callback is rejected.
+func LoadContractDeployments(file string) (*ContractDeployments, error) {
+	d := &ContractDeployments{
+		deployments: make(map[string]*ContractDeployment),
+	}
+
+	fileBytes, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("%v not found, no contract deployment is registered\n", file)
+			return d, nil
+		}
+		return nil, fmt.Errorf("failed to read %v, err: %v", file, err)
+	}
+
+	var contractsByHash map[string]string
+	if err := json.Unmarshal(fileBytes, &contractsByHash); err != nil {
+		return nil, fmt.Errorf("failed to parse %v, err: %v", file, err)
+	}
+
+	for hash, contract := range contractsByHash {
+		if contract == "" {
+			return nil, fmt.Errorf("smart contract %v is not mapped to a contract in %v", hash, file)
+		}
+		if _, ok := contractRegistry[contract]; !ok {
+			return nil, fmt.Errorf("smart contract %v is mapped to %v in %v, which is not a registered contract", hash, contract, file)
+		}
+
+		d.deployments[hash] = &ContractDeployment{
+			SmartContractHash: hash,
+			Contract:          contract,
+		}
+	}
+
+	return d, nil
+}
+
+// Verify checks that smartContractHash is a deployment of the registered
+// contract name, and that the deployed binary is the one served locally.
+// The chain registration and the deployed binary are looked up once, as
+// neither changes after the deployment. The lookups are made without holding
+// the lock, so that callbacks of other deployments do not wait on them.
+func (d *ContractDeployments) Verify(name string, smartContractHash string, localDigest string) error {
+	if smartContractHash == "" {
+		return fmt.Errorf("smart_contract_hash is required")
+	}
+
+	d.mu.Lock()
+	deployment, ok := d.deployments[smartContractHash]
+	var registered bool
+	var deployedDigest string
+	if ok {
+		registered = deployment.registered
+		deployedDigest = deployment.deployedDigest
+	}
+	d.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("smart contract %v is not a known deployment, it must be mapped to a contract in %v", smartContractHash, DEPLOYED_CONTRACTS_FILE)
+	}
+
+	if deployment.Contract != name {
+		return fmt.Errorf("smart contract %v is a deployment of %v, not of %v", smartContractHash, deployment.Contract, name)
+	}
+
+	if !registered {
+		transactions, err := listSmartContractTransactions(smartContractHash)
+		if err != nil {
+			return fmt.Errorf("unable to check the registration of smart contract %v, err: %v", smartContractHash, err)
+		}
+		if len(transactions.SCTDataReply) == 0 {
+			return fmt.Errorf("Smart Contract Token %v is not registered", smartContractHash)
+		}
+
+		d.mu.Lock()
+		deployment.registered = true
+		d.mu.Unlock()
+	}
+
+	if deployedDigest == "" {
+		digest, err := deployedBinaryDigest(smartContractHash)
+		if err != nil {
+			return err
+		}
+		deployedDigest = digest
+
+		d.mu.Lock()
+		deployment.deployedDigest = digest
+		d.mu.Unlock()
+	}
+
+	if deployedDigest != localDigest {
+		return fmt.Errorf("local artifact of %v (sha256 %v) differs from the deployed smart contract %v (sha256 %v)", name, localDigest, smartContractHash, deployedDigest)
+	}
+
+	return nil
+}
+
+// deployedBinaryDigest returns the sha256 digest of the smart contract binary
+// stored by the Rubix node under RUBIX_SMART_CONTRACT_DIR
+func deployedBinaryDigest(smartContractHash string) (string, error) {
+	rubixSmartContractDir := os.Getenv("RUBIX_SMART_CONTRACT_DIR")
+	if rubixSmartContractDir == "" {
+		return "", fmt.Errorf("RUBIX_SMART_CONTRACT_DIR is not set, unable to verify the binary of smart contract %v", smartContractHash)
+	}
+
+	binaryPath := path.Join(rubixSmartContractDir, smartContractHash, DEPLOYED_BINARY_FILE)
+	binaryBytes, err := os.ReadFile(binaryPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the deployed binary of smart contract %v, err: %v", smartContractHash, err)
+	}
+
+	digest := sha256.Sum256(binaryBytes)
+	return hex.EncodeToString(digest[:]), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLoadContractDeployments(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		err      string
+	}{
+		{
+			name:     "shipped deployments",
+			contents: "",
+		},
+		{
+			name:     "registered contract",
+			contents: `{"contract": "upload_asset"}`,
+		},
+		{
+			name:     "empty contract name",
+			contents: `{"contract": ""}`,
+			err:      "not mapped to a contract",
+		},
+		{
+			name:     "unknown contract",
+			contents: `{"contract": "unknown"}`,
+			err:      "not a registered contract",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := DEPLOYED_CONTRACTS_FILE
+			if tt.contents != "" {
+				file = path.Join(t.TempDir(), "deployed_contracts.json")
+				if err := os.WriteFile(file, []byte(tt.contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := LoadContractDeployments(file)
+			if tt.err == "" && err != nil {
+				t.Fatalf("LoadContractDeployments() error = %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("LoadContractDeployments() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestContractDeploymentsVerify(t *testing.T) {
+	// The deployment is already known to be registered, so that its
+	// verification makes no network call
+	deployments := &ContractDeployments{
+		deployments: map[string]*ContractDeployment{
+			"contract": {SmartContractHash: "contract", Contract: "upload_asset", registered: true, deployedDigest: "digest"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		contract string
+		hash     string
+		digest   string
+		err      string
+	}{
+		{name: "deployment of the contract", contract: "upload_asset", hash: "contract", digest: "digest"},
+		{name: "deployment of another contract", contract: "use_asset", hash: "contract", digest: "digest", err: "not of use_asset"},
+		{name: "unknown deployment", contract: "upload_asset", hash: "unknown", digest: "digest", err: "not a known deployment"},
+		{name: "different binary", contract: "upload_asset", hash: "contract", digest: "other", err: "differs from the deployed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := deployments.Verify(tt.contract, tt.hash, tt.digest)
+			if tt.err == "" && err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("Verify() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
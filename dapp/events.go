@@ -0,0 +1,247 @@
+package main
+
+import (
+	"dapp/events"
+	"dapp/wallet"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// MESSAGE_SUBSCRIBE adds topics and DIDs to the events pushed to a client
+	MESSAGE_SUBSCRIBE = "SUBSCRIBE"
+	// MESSAGE_UNSUBSCRIBE removes topics and DIDs from them
+	MESSAGE_UNSUBSCRIBE = "UNSUBSCRIBE"
+	// MESSAGE_EVENT carries an event pushed to a client
+	MESSAGE_EVENT = "EVENT"
+)
+
+const DEFAULT_RATING_POLL_INTERVAL = 30 * time.Second
+
+// parseFilter reads the topics and DIDs of a SUBSCRIBE or UNSUBSCRIBE
+// message, rejecting unknown topics
+func parseFilter(data json.RawMessage) (events.Filter, error) {
+	var request struct {
+		Topics []string `json:"topics"`
+		DIDs   []string `json:"dids"`
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return events.Filter{}, fmt.Errorf("malformed subscription, err: %v", err)
+	}
+
+	return newFilter(request.Topics, request.DIDs)
+}
+
+func newFilter(topicNames []string, dids []string) (events.Filter, error) {
+	filter := events.Filter{DIDs: dids}
+	for _, name := range topicNames {
+		topic, err := events.ParseTopic(name)
+		if err != nil {
+			return events.Filter{}, err
+		}
+		filter.Topics = append(filter.Topics, topic)
+	}
+
+	return filter, nil
+}
+
+// subscriptionHandlers lets the client of a broker change the events of sub
+// with SUBSCRIBE and UNSUBSCRIBE messages. Both are answered with the topics
+// and DIDs subscribed to.
+func subscriptionHandlers(sub *events.Subscription) []wallet.BrokerOption {
+	return []wallet.BrokerOption{
+		wallet.WithMessageHandler(MESSAGE_SUBSCRIBE, func(data json.RawMessage) (interface{}, error) {
+			filter, err := parseFilter(data)
+			if err != nil {
+				return nil, err
+			}
+			if err := sub.Add(filter); err != nil {
+				return nil, err
+			}
+			return sub.Filter(), nil
+		}),
+		wallet.WithMessageHandler(MESSAGE_UNSUBSCRIBE, func(data json.RawMessage) (interface{}, error) {
+			filter, err := parseFilter(data)
+			if err != nil {
+				return nil, err
+			}
+			sub.Remove(filter)
+			return sub.Filter(), nil
+		}),
+	}
+}
+
+// forwardEvents pushes the events of sub to the client of broker, until sub
+// is closed. Events published while the client is disconnected are lost.
+func forwardEvents(sub *events.Subscription, broker *wallet.Broker) {
+	for event := range sub.Events() {
+		err := broker.Push(MESSAGE_EVENT, event)
+		if err != nil && !errors.Is(err, wallet.ErrDisconnected) {
+			fmt.Printf("unable to push %v event, err: %v\n", event.Topic, err)
+		}
+	}
+}
+
+// handleEventsConnection serves the event stream of a frontend, which
+// receives the events about the DID given in the did query parameter. As on
+// /ws, the client must first sign a challenge with the key of that DID. The
+// initial topics are given as a comma separated list in the topics query
+// parameter, and all the events of the DID are sent when there is none.
+func handleEventsConnection(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+
+	did := r.URL.Query().Get("did")
+	if did == "" {
+		http.Error(w, "did is required", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := newFilter(splitList(r.URL.Query().Get("topics")), splitList(r.URL.Query().Get("dids")))
+	if err == nil {
+		err = filter.Validate(did)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(filter.Topics) == 0 {
+		filter.DIDs = []string{did}
+	}
+
+	conn, err := Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to upgrade connection: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := wallet.Authenticate(conn, did, verifyDIDSignature); err != nil {
+		fmt.Printf("Event stream authentication failed: %v, err: %v\n", did, err)
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authentication failed"), time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+
+	sub, err := EventBus.Subscribe(did, filter)
+	if err != nil {
+		fmt.Printf("Event stream refused: %v, err: %v\n", did, err)
+		conn.Close()
+		return
+	}
+	defer sub.Close()
+
+	broker := wallet.NewBroker(conn, append([]wallet.BrokerOption{heartbeatOption()}, subscriptionHandlers(sub)...)...)
+	release, err := Sockets.Track(broker)
+	if err != nil {
+		fmt.Printf("Event stream refused, err: %v\n", err)
+		return
+	}
+	defer release()
+
+	go forwardEvents(sub, broker)
+
+	<-broker.Done()
+	fmt.Printf("Event stream closed, err: %v\n", broker.Err())
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// watchRatings polls the chain of the rating contract every interval until
+// stop is closed, and publishes the ratings added since the previous poll.
+// Ratings are submitted to the chain directly, so this is the only way the
+// dapp learns about them.
+func watchRatings(publisher events.Publisher, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// The ratings found on the first successful poll are not new
+	var seen map[string]bool
+	for {
+		seen = publishNewRatings(publisher, seen)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishNewRatings publishes the ratings of the blocks missing from seen,
+// and returns the blocks seen so far. Nothing is published when seen is nil.
+func publishNewRatings(publisher events.Publisher, seen map[string]bool) map[string]bool {
+	transactions, err := listSmartContractTransactions(RATING_CONTRACT_HASH)
+	if err != nil {
+		fmt.Printf("unable to fetch the ratings, err: %v\n", err)
+		return seen
+	}
+
+	initialized := seen != nil
+	if !initialized {
+		seen = make(map[string]bool)
+	}
+
+	var owners map[string]string
+	for _, reply := range transactions.SCTDataReply {
+		if seen[reply.BlockId] {
+			continue
+		}
+		seen[reply.BlockId] = true
+		if !initialized {
+			continue
+		}
+
+		var wrapper WrappedRating
+		if err := json.Unmarshal([]byte(reply.SmartContractData), &wrapper); err != nil {
+			continue
+		}
+		rating := wrapper.RateAsset
+		if rating.AssetID == "" || rating.Rating < 1 || rating.Rating > 5 {
+			continue
+		}
+
+		// The owner of the asset is told about the rating as well
+		if owners == nil {
+			owners, err = listNFTOwners()
+			if err != nil {
+				fmt.Printf("unable to fetch the owners of the rated assets, err: %v\n", err)
+				owners = map[string]string{}
+			}
+		}
+		dids := []string{rating.UserDID}
+		if owner := owners[rating.AssetID]; owner != "" {
+			dids = append(dids, owner)
+		}
+
+		publisher.Publish(events.TopicRatingReceived, map[string]interface{}{
+			"asset_id":  rating.AssetID,
+			"user_did":  rating.UserDID,
+			"owner_did": owners[rating.AssetID],
+			"rating":    rating.Rating,
+			"block_id":  reply.BlockId,
+		}, dids...)
+	}
+
+	return seen
+}
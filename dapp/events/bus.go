@@ -0,0 +1,249 @@
+// Package events carries the notifications published by the dapp to the
+// wallets and frontends subscribed to them
+package events
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SUBSCRIPTION_BUFFER_SIZE is the number of events kept for a subscriber
+// which is not keeping up. Further events are dropped for it.
+const SUBSCRIPTION_BUFFER_SIZE = 64
+
+type Topic string
+
+const (
+	TopicAssetPublished    Topic = "asset.published"
+	TopicAssetTransferred  Topic = "asset.transferred"
+	TopicCreditsAdded      Topic = "credits.added"
+	TopicCreditsDeducted   Topic = "credits.deducted"
+	TopicProviderOnboarded Topic = "provider.onboarded"
+	TopicHostingFeePaid    Topic = "hosting_fee.paid"
+	TopicRatingReceived    Topic = "rating.received"
+)
+
+// Topics lists every topic published by the dapp
+var Topics = []Topic{
+	TopicAssetPublished,
+	TopicAssetTransferred,
+	TopicCreditsAdded,
+	TopicCreditsDeducted,
+	TopicProviderOnboarded,
+	TopicHostingFeePaid,
+	TopicRatingReceived,
+}
+
+// Event is a notification of something that happened in the dapp. DIDs are
+// the DIDs the event is about, such as the owner of a published asset.
+type Event struct {
+	Topic Topic       `json:"topic"`
+	DIDs  []string    `json:"dids"`
+	Data  interface{} `json:"data"`
+	Time  time.Time   `json:"time"`
+}
+
+// Publisher is handed to the host functions and handlers publishing events
+type Publisher interface {
+	Publish(topic Topic, data interface{}, dids ...string)
+}
+
+type discard struct{}
+
+func (discard) Publish(topic Topic, data interface{}, dids ...string) {}
+
+// Discard publishes nothing. It is used by dry runs of the contracts.
+var Discard Publisher = discard{}
+
+// ParseTopic checks that name is one of the topics published by the dapp
+func ParseTopic(name string) (Topic, error) {
+	for _, topic := range Topics {
+		if string(topic) == name {
+			return topic, nil
+		}
+	}
+	return "", fmt.Errorf("unknown topic %q", name)
+}
+
+// Filter selects events by topic or by DID. An event matches the filter
+// when its topic is one of Topics, or when it is about one of DIDs.
+type Filter struct {
+	Topics []Topic  `json:"topics"`
+	DIDs   []string `json:"dids"`
+}
+
+// Validate checks that the filter names no other DID than owner, the DID
+// authenticated by the subscriber
+func (f Filter) Validate(owner string) error {
+	for _, did := range f.DIDs {
+		if did != owner {
+			return fmt.Errorf("cannot subscribe to the events of %v, only to those of %v", did, owner)
+		}
+	}
+	return nil
+}
+
+// Bus delivers the published events to the subscriptions matching them
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish delivers an event to every matching subscription. It never
+// blocks, a subscription with a full buffer misses the event.
+func (b *Bus) Publish(topic Topic, data interface{}, dids ...string) {
+	event := Event{
+		Topic: topic,
+		DIDs:  dids,
+		Data:  data,
+		Time:  time.Now(),
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscriptions {
+		if !sub.matches(&event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			fmt.Printf("dropping %v event, the subscriber is not keeping up\n", topic)
+		}
+	}
+}
+
+// Subscribe returns a subscription of owner to the events matching filter.
+// Only the events about owner are delivered, whatever the filter. The filter
+// can be changed later with Add and Remove.
+func (b *Bus) Subscribe(owner string, filter Filter) (*Subscription, error) {
+	if owner == "" {
+		return nil, fmt.Errorf("subscriptions must be made by an authenticated DID")
+	}
+
+	sub := &Subscription{
+		bus:    b,
+		owner:  owner,
+		topics: make(map[Topic]bool),
+		dids:   make(map[string]bool),
+		events: make(chan Event, SUBSCRIPTION_BUFFER_SIZE),
+	}
+	if err := sub.Add(filter); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.subscriptions[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub, nil
+}
+
+// Subscription receives the events published on a bus which are about its
+// owner and match its topics and DIDs
+type Subscription struct {
+	bus   *Bus
+	owner string
+
+	mu     sync.RWMutex
+	topics map[Topic]bool
+	dids   map[string]bool
+
+	events    chan Event
+	closeOnce sync.Once
+}
+
+// Events is the channel on which the events are received. It is closed
+// once the subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Add subscribes to the topics and DIDs of filter, in addition to those
+// already subscribed to
+func (s *Subscription) Add(filter Filter) error {
+	if err := filter.Validate(s.owner); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, topic := range filter.Topics {
+		s.topics[topic] = true
+	}
+	for _, did := range filter.DIDs {
+		s.dids[did] = true
+	}
+	return nil
+}
+
+// Remove unsubscribes from the topics and DIDs of filter
+func (s *Subscription) Remove(filter Filter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, topic := range filter.Topics {
+		delete(s.topics, topic)
+	}
+	for _, did := range filter.DIDs {
+		delete(s.dids, did)
+	}
+}
+
+// Filter returns the topics and DIDs currently subscribed to
+func (s *Subscription) Filter() Filter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filter := Filter{
+		Topics: make([]Topic, 0, len(s.topics)),
+		DIDs:   make([]string, 0, len(s.dids)),
+	}
+	for topic := range s.topics {
+		filter.Topics = append(filter.Topics, topic)
+	}
+	for did := range s.dids {
+		filter.DIDs = append(filter.DIDs, did)
+	}
+	return filter
+}
+
+func (s *Subscription) matches(event *Event) bool {
+	if !slices.Contains(event.DIDs, s.owner) {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.topics[event.Topic] {
+		return true
+	}
+	for _, did := range event.DIDs {
+		if s.dids[did] {
+			return true
+		}
+	}
+	return false
+}
+
+// Close removes the subscription from its bus and closes its channel
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subscriptions, s)
+		s.bus.mu.Unlock()
+
+		close(s.events)
+	})
+}
@@ -0,0 +1,170 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+// received drains the events queued for sub
+func received(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestBusFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		topic  Topic
+		dids   []string
+		want   bool
+	}{
+		{name: "subscribed topic", filter: Filter{Topics: []Topic{TopicCreditsAdded}}, topic: TopicCreditsAdded, dids: []string{"owner"}, want: true},
+		{name: "other topic", filter: Filter{Topics: []Topic{TopicCreditsAdded}}, topic: TopicAssetPublished, dids: []string{"owner"}, want: false},
+		{name: "subscribed DID", filter: Filter{DIDs: []string{"owner"}}, topic: TopicAssetPublished, dids: []string{"other", "owner"}, want: true},
+		{name: "subscribed topic about another DID", filter: Filter{Topics: []Topic{TopicCreditsAdded}}, topic: TopicCreditsAdded, dids: []string{"other"}, want: false},
+		{name: "event about no DID", filter: Filter{Topics: []Topic{TopicRatingReceived}}, topic: TopicRatingReceived, want: false},
+		{name: "empty filter", topic: TopicCreditsAdded, dids: []string{"owner"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := NewBus()
+			sub, err := bus.Subscribe("owner", tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+
+			bus.Publish(tt.topic, "data", tt.dids...)
+
+			events := received(sub)
+			if got := len(events) == 1; got != tt.want {
+				t.Fatalf("received %d events, want the event delivered %v", len(events), tt.want)
+			}
+			if tt.want && (events[0].Topic != tt.topic || events[0].Data != "data") {
+				t.Fatalf("received %+v, want a %v event", events[0], tt.topic)
+			}
+		})
+	}
+}
+
+func TestBusSubscribe(t *testing.T) {
+	bus := NewBus()
+
+	tests := []struct {
+		name   string
+		owner  string
+		filter Filter
+		err    bool
+	}{
+		{name: "own DID", owner: "owner", filter: Filter{DIDs: []string{"owner"}}},
+		{name: "another DID", owner: "owner", filter: Filter{DIDs: []string{"other"}}, err: true},
+		{name: "anonymous subscriber", filter: Filter{Topics: []Topic{TopicCreditsAdded}}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, err := bus.Subscribe(tt.owner, tt.filter)
+			if (err != nil) != tt.err {
+				t.Fatalf("Subscribe() error = %v, want error %v", err, tt.err)
+			}
+			if sub != nil {
+				sub.Close()
+			}
+		})
+	}
+}
+
+func TestSubscriptionAddRemove(t *testing.T) {
+	bus := NewBus()
+	sub, err := bus.Subscribe("owner", Filter{Topics: []Topic{TopicCreditsAdded}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	if err := sub.Add(Filter{Topics: []Topic{TopicCreditsDeducted}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Add(Filter{DIDs: []string{"other"}}); err == nil {
+		t.Fatal("Add() of another DID succeeded")
+	}
+	sub.Remove(Filter{Topics: []Topic{TopicCreditsAdded}})
+
+	bus.Publish(TopicCreditsAdded, nil, "owner")
+	bus.Publish(TopicCreditsDeducted, nil, "owner")
+
+	events := received(sub)
+	if len(events) != 1 || events[0].Topic != TopicCreditsDeducted {
+		t.Fatalf("received %+v, want a single %v event", events, TopicCreditsDeducted)
+	}
+	if filter := sub.Filter(); len(filter.Topics) != 1 || filter.Topics[0] != TopicCreditsDeducted {
+		t.Fatalf("Filter() = %+v, want only %v", filter, TopicCreditsDeducted)
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	bus := NewBus()
+	sub, err := bus.Subscribe("owner", Filter{DIDs: []string{"owner"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub.Close()
+	sub.Close()
+
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("Events() delivered an event after Close()")
+	}
+	// Publishing to no subscription does not send on the closed channel
+	bus.Publish(TopicCreditsAdded, nil, "owner")
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	slow, err := bus.Subscribe("owner", Filter{DIDs: []string{"owner"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	fast, err := bus.Subscribe("owner", Filter{DIDs: []string{"owner"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Close()
+
+	published := make(chan struct{})
+	go func() {
+		for n := 0; n < SUBSCRIPTION_BUFFER_SIZE+10; n++ {
+			bus.Publish(TopicCreditsAdded, n, "owner")
+			// The fast subscriber keeps up
+			<-fast.Events()
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish() blocked on a subscriber not reading its events")
+	}
+
+	events := received(slow)
+	if len(events) != SUBSCRIPTION_BUFFER_SIZE {
+		t.Fatalf("slow subscriber received %d events, want the %d buffered", len(events), SUBSCRIPTION_BUFFER_SIZE)
+	}
+	if events[0].Data != 0 {
+		t.Fatalf("first event of the slow subscriber = %v, want the first published", events[0].Data)
+	}
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"dapp/events"
+	"dapp/host/credits"
+	"dapp/host/ft"
+	"dapp/wallet"
+	"dapp/wasmcache"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/gin-gonic/gin"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+	"github.com/syndtr/goleveldb/leveldb"
+	leveldbUtil "github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const EXECUTION_STORAGE = "./executionstorage"
+const EXECUTION_KEY_PREFIX = "exec:"
+
+type ExecutionStatus string
+
+const (
+	ExecutionRunning     ExecutionStatus = "running"
+	ExecutionSucceeded   ExecutionStatus = "succeeded"
+	ExecutionFailed      ExecutionStatus = "failed"
+	ExecutionTimedOut    ExecutionStatus = "timed_out"
+	ExecutionOutOfFuel   ExecutionStatus = "out_of_fuel"
+	ExecutionOutOfMemory ExecutionStatus = "out_of_memory"
+)
+
+// executionStatusOf returns the status of an execution which ended with err
+func executionStatusOf(err error) ExecutionStatus {
+	switch {
+	case err == nil:
+		return ExecutionSucceeded
+	case errors.Is(err, wasmcache.ErrTimedOut):
+		return ExecutionTimedOut
+	case errors.Is(err, wasmcache.ErrOutOfFuel):
+		return ExecutionOutOfFuel
+	case errors.Is(err, wasmcache.ErrMemoryLimit):
+		return ExecutionOutOfMemory
+	default:
+		return ExecutionFailed
+	}
+}
+
+// WalletExchange is a single extension command sent to the wallet
+// and the reply received for it
+type WalletExchange struct {
+	Action     string          `json:"action"`
+	Request    json.RawMessage `json:"request"`
+	Response   string          `json:"response,omitempty"`
+	Outcome    wallet.Outcome  `json:"outcome"`
+	Error      string          `json:"error,omitempty"`
+	SentAt     time.Time       `json:"sent_at"`
+	DurationMs int64           `json:"duration_ms"`
+}
+
+// HostCallRecord is a single call of a host function by the contract. Code
+// is the code returned to the contract, which tells why a wallet command
+// was not approved.
+type HostCallRecord struct {
+	Name            string            `json:"name"`
+	Input           json.RawMessage   `json:"input,omitempty"`
+	Code            int32             `json:"code"`
+	Error           string            `json:"error,omitempty"`
+	StartedAt       time.Time         `json:"started_at"`
+	DurationMs      int64             `json:"duration_ms"`
+	WalletExchanges []*WalletExchange `json:"wallet_exchanges,omitempty"`
+}
+
+type ExecutionRecord struct {
+	ID                string            `json:"id"`
+	Contract          string            `json:"contract"`
+	SmartContractHash string            `json:"smart_contract_hash"`
+	InitiatorDID      string            `json:"initiator_did"`
+	Input             string            `json:"input"`
+	Status            ExecutionStatus   `json:"status"`
+	WalletOutcome     wallet.Outcome    `json:"wallet_outcome,omitempty"`
+	HostCalls         []*HostCallRecord `json:"host_calls"`
+	Output            string            `json:"output,omitempty"`
+	Result            string            `json:"result,omitempty"`
+	Error             string            `json:"error,omitempty"`
+	StartedAt         time.Time         `json:"started_at"`
+	FinishedAt        *time.Time        `json:"finished_at,omitempty"`
+	DurationMs        int64             `json:"duration_ms"`
+}
+
+// redacted returns a copy of the record without the contract input and
+// output, nor the payloads of the host calls and wallet commands, which are
+// only shown to operators
+func (r *ExecutionRecord) redacted() *ExecutionRecord {
+	redacted := *r
+	redacted.Input = ""
+	redacted.Output = ""
+	redacted.Result = ""
+
+	redacted.HostCalls = make([]*HostCallRecord, 0, len(r.HostCalls))
+	for _, call := range r.HostCalls {
+		redactedCall := *call
+		redactedCall.Input = nil
+
+		redactedCall.WalletExchanges = make([]*WalletExchange, 0, len(call.WalletExchanges))
+		for _, exchange := range call.WalletExchanges {
+			redactedExchange := *exchange
+			redactedExchange.Request = nil
+			redactedExchange.Response = ""
+			redactedCall.WalletExchanges = append(redactedCall.WalletExchanges, &redactedExchange)
+		}
+
+		redacted.HostCalls = append(redacted.HostCalls, &redactedCall)
+	}
+
+	return &redacted
+}
+
+// ExecutionJournal persists a record of every contract execution
+type ExecutionJournal struct {
+	db *leveldb.DB
+}
+
+func NewExecutionJournal(db *leveldb.DB) *ExecutionJournal {
+	return &ExecutionJournal{db: db}
+}
+
+func newExecutionID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	// Prefixing with the start time keeps the journal keys in execution order
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(suffix))
+}
+
+func (j *ExecutionJournal) save(record *ExecutionRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal execution record: %v", err)
+	}
+
+	err = j.db.Put([]byte(EXECUTION_KEY_PREFIX+record.ID), recordBytes, nil)
+	if err != nil {
+		return fmt.Errorf("failed to store execution record %s: %v", record.ID, err)
+	}
+
+	return nil
+}
+
+func (j *ExecutionJournal) Get(id string) (*ExecutionRecord, error) {
+	recordBytes, err := j.db.Get([]byte(EXECUTION_KEY_PREFIX+id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var record *ExecutionRecord
+	if err := json.Unmarshal(recordBytes, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal execution record %s: %v", id, err)
+	}
+
+	return record, nil
+}
+
+type ExecutionFilter struct {
+	DID      string
+	Contract string
+	Status   ExecutionStatus
+	Limit    int
+}
+
+func (f *ExecutionFilter) matches(record *ExecutionRecord) bool {
+	if f.DID != "" && record.InitiatorDID != f.DID {
+		return false
+	}
+	if f.Contract != "" && record.Contract != f.Contract {
+		return false
+	}
+	if f.Status != "" && record.Status != f.Status {
+		return false
+	}
+	return true
+}
+
+// List returns the execution records matching filter, newest first
+func (j *ExecutionJournal) List(filter *ExecutionFilter) ([]*ExecutionRecord, error) {
+	iter := j.db.NewIterator(leveldbUtil.BytesPrefix([]byte(EXECUTION_KEY_PREFIX)), nil)
+	defer iter.Release()
+
+	records := make([]*ExecutionRecord, 0)
+	for ok := iter.Last(); ok && len(records) < filter.Limit; ok = iter.Prev() {
+		var record *ExecutionRecord
+		if err := json.Unmarshal(iter.Value(), &record); err != nil {
+			fmt.Printf("skipping unreadable execution record %s: %v\n", string(iter.Key()), err)
+			continue
+		}
+
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to iterate execution records: %v", err)
+	}
+
+	return records, nil
+}
+
+// Execution tracks a contract run in progress and keeps its journal
+// record up to date
+type Execution struct {
+	Record *ExecutionRecord
+
+	// Signer is the journaling signer handed to the host functions. It is nil
+	// when the contract does not need a wallet.
+	Signer wallet.Signer
+
+	// Simulated is set for dry runs, which are neither journaled nor allowed
+	// to reach the wallet
+	Simulated bool
+
+	// Events publishes the notifications of the host functions. Dry runs
+	// publish nothing.
+	Events events.Publisher
+
+	// Credits is the credit ledger of the host functions, which dry runs
+	// only read
+	Credits *credits.Ledger
+
+	// Payments records the FT transfers made by the contract, which pay for
+	// the credits it grants
+	Payments *ft.Payments
+
+	// CreditPrice is what the credits granted by the contract must be paid
+	CreditPrice credits.Price
+
+	// ctx is done once the execution reaches its deadline
+	ctx context.Context
+
+	journal      *ExecutionJournal
+	mu           sync.Mutex
+	currentCall  *HostCallRecord
+	onWalletWait func(waiting bool)
+	creditGrants []credits.Grant
+	deductions   []credits.Deduction
+}
+
+// ExecutionOption allows us to configure an Execution once it has begun
+type ExecutionOption func(*Execution)
+
+func newExecution(ctx context.Context, contract string, contractInputRequest *ContractInputRequest) *Execution {
+	return &Execution{
+		ctx:      ctx,
+		Events:   events.Discard,
+		Payments: ft.NewPayments(),
+		Record: &ExecutionRecord{
+			ID:                newExecutionID(),
+			Contract:          contract,
+			SmartContractHash: contractInputRequest.SmartContractHash,
+			InitiatorDID:      contractInputRequest.InitiatorDID,
+			Input:             contractInputRequest.SmartContractData,
+			Status:            ExecutionRunning,
+			HostCalls:         make([]*HostCallRecord, 0),
+			StartedAt:         time.Now(),
+		},
+	}
+}
+
+// Begin creates the journal record of a contract execution
+func (j *ExecutionJournal) Begin(ctx context.Context, contract string, contractInputRequest *ContractInputRequest, opts ...ExecutionOption) *Execution {
+	exec := newExecution(ctx, contract, contractInputRequest)
+	exec.journal = j
+
+	exec.persist()
+
+	for _, opt := range opts {
+		opt(exec)
+	}
+
+	return exec
+}
+
+func (x *Execution) persist() {
+	if x.journal == nil {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err := x.journal.save(x.Record); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Finish records the outcome of the execution
+func (x *Execution) Finish(result string, err error) {
+	x.mu.Lock()
+	finishedAt := time.Now()
+	x.Record.FinishedAt = &finishedAt
+	x.Record.DurationMs = finishedAt.Sub(x.Record.StartedAt).Milliseconds()
+	x.Record.Status = executionStatusOf(err)
+	if err != nil {
+		x.Record.Error = err.Error()
+	} else {
+		x.Record.Result = result
+	}
+	x.mu.Unlock()
+
+	x.persist()
+}
+
+// SetOutput records the raw output returned by the contract
+func (x *Execution) SetOutput(output string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.Record.Output = output
+}
+
+func (x *Execution) addCreditGrant(grant credits.Grant) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.creditGrants = append(x.creditGrants, grant)
+}
+
+// CreditGrants returns the credits added to the ledger by the contract
+func (x *Execution) CreditGrants() []credits.Grant {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return append([]credits.Grant(nil), x.creditGrants...)
+}
+
+func (x *Execution) addCreditDeduction(deduction credits.Deduction) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.deductions = append(x.deductions, deduction)
+}
+
+// CreditDeductions returns the credits taken from the ledger by the contract
+func (x *Execution) CreditDeductions() []credits.Deduction {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return append([]credits.Deduction(nil), x.deductions...)
+}
+
+// UseSigner wraps signer so that every command sent through it is recorded
+// against the host call in progress
+func (x *Execution) UseSigner(signer wallet.Signer) {
+	x.Signer = &journalSigner{signer: signer, exec: x}
+}
+
+func (x *Execution) beginHostCall(name string, input []byte) *HostCallRecord {
+	call := &HostCallRecord{
+		Name:      name,
+		StartedAt: time.Now(),
+	}
+	if json.Valid(input) {
+		call.Input = append(json.RawMessage{}, input...)
+	}
+
+	x.mu.Lock()
+	x.Record.HostCalls = append(x.Record.HostCalls, call)
+	x.currentCall = call
+	x.mu.Unlock()
+
+	return call
+}
+
+func (x *Execution) endHostCall(call *HostCallRecord, results []wasmtime.Val, trap *wasmtime.Trap) {
+	x.mu.Lock()
+	call.DurationMs = time.Since(call.StartedAt).Milliseconds()
+	if trap != nil {
+		call.Code = wallet.CODE_FAILED
+		call.Error = trap.Message()
+	} else if len(results) > 0 && results[0].Kind() == wasmtime.KindI32 {
+		call.Code = results[0].I32()
+	}
+	x.currentCall = nil
+	x.mu.Unlock()
+
+	x.persist()
+}
+
+func (x *Execution) recordExchange(exchange *WalletExchange) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	// The first command which is not approved tells why the execution
+	// failed, as the contract usually gives up on it
+	if exchange.Outcome != wallet.OutcomeApproved && x.Record.WalletOutcome == "" {
+		x.Record.WalletOutcome = exchange.Outcome
+	}
+
+	if x.currentCall != nil {
+		x.currentCall.WalletExchanges = append(x.currentCall.WalletExchanges, exchange)
+	}
+}
+
+// WrapHostFunctions records every invocation of the given host functions
+// against the execution
+func (x *Execution) WrapHostFunctions(hostFns []host.HostFunction) []host.HostFunction {
+	wrapped := make([]host.HostFunction, 0, len(hostFns))
+	for _, hostFn := range hostFns {
+		wrapped = append(wrapped, &recordedHostFunction{HostFunction: hostFn, exec: x})
+	}
+	return wrapped
+}
+
+type recordedHostFunction struct {
+	host.HostFunction
+	exec *Execution
+}
+
+func (h *recordedHostFunction) Callback() host.HostFunctionCallBack {
+	callback := h.HostFunction.Callback()
+
+	return func(caller *wasmtime.Caller, args []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
+		// The contract is stopped at the first host call made past its deadline
+		if err := h.exec.ctx.Err(); err != nil {
+			return nil, wasmtime.NewTrap(fmt.Sprintf("%v not called, err: %v", h.Name(), err))
+		}
+
+		// The input is only recorded when the first two arguments point at
+		// a JSON payload, as some host functions take output arguments only
+		var input []byte
+		if len(args) >= 2 {
+			inputArgs, _ := utils.HostFunctionParamExtraction(args, true, false)
+			if inputArgs != nil {
+				input, _, _ = utils.ExtractDataFromWASM(caller, inputArgs)
+			}
+		}
+
+		call := h.exec.beginHostCall(h.Name(), input)
+		results, trap := callback(caller, args)
+		h.exec.endHostCall(call, results, trap)
+
+		return results, trap
+	}
+}
+
+type journalSigner struct {
+	signer wallet.Signer
+	exec   *Execution
+}
+
+func (s *journalSigner) Send(action string, payload interface{}) ([]byte, error) {
+	exchange := &WalletExchange{
+		Action: action,
+		SentAt: time.Now(),
+	}
+	if payloadBytes, err := json.Marshal(payload); err == nil {
+		exchange.Request = payloadBytes
+	}
+
+	if s.exec.onWalletWait != nil {
+		s.exec.onWalletWait(true)
+		defer s.exec.onWalletWait(false)
+	}
+
+	var resp []byte
+	var err error
+	if signer, ok := s.signer.(wallet.ContextSigner); ok {
+		resp, err = signer.SendContext(s.exec.ctx, action, payload)
+	} else {
+		resp, err = s.signer.Send(action, payload)
+	}
+
+	exchange.DurationMs = time.Since(exchange.SentAt).Milliseconds()
+	exchange.Response = string(resp)
+	exchange.Outcome = wallet.OutcomeOf(err)
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	s.exec.recordExchange(exchange)
+
+	return resp, err
+}
+
+func (s *Server) handleListExecutions(c *gin.Context) {
+	w := http.ResponseWriter(c.Writer)
+	enableCors(&w)
+
+	filter := &ExecutionFilter{
+		DID:      c.Query("did"),
+		Contract: c.Query("contract"),
+		Status:   ExecutionStatus(c.Query("status")),
+		Limit:    50,
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			getClientError(c, "limit must be a positive integer")
+			return
+		}
+		filter.Limit = min(limit, 500)
+	}
+
+	records, err := s.Journal.List(filter)
+	if err != nil {
+		getInternalError(c, err.Error())
+		return
+	}
+
+	if !isAdmin(c) {
+		for i, record := range records {
+			records[i] = record.redacted()
+		}
+	}
+
+	c.JSON(http.StatusOK, records)
+}
+
+func (s *Server) handleGetExecution(c *gin.Context) {
+	w := http.ResponseWriter(c.Writer)
+	enableCors(&w)
+
+	id := c.Param("id")
+
+	record, err := s.Journal.Get(id)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"status": false, "error": fmt.Sprintf("execution %s not found", id)})
+			return
+		}
+		getInternalError(c, err.Error())
+		return
+	}
+
+	if !isAdmin(c) {
+		record = record.redacted()
+	}
+
+	c.JSON(http.StatusOK, record)
+}
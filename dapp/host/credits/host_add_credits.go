@@ -3,20 +3,65 @@ package credits
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 
 	"github.com/bytecodealliance/wasmtime-go"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+
+	"dapp/host/ft"
 )
 
+// Grant is the credits added to a DID for one of its payments
+type Grant struct {
+	UserDid string `json:"user_did"`
+	Credit  uint   `json:"credit"`
+	TxID    string `json:"tx_id"`
+}
+
+// Price is what a credit is sold for: FTCount FTs named FTName, minted by
+// FTCreator unless it is empty, paid to Receiver. Credits are not sold when
+// there is no Receiver.
+type Price struct {
+	Receiver  string
+	FTName    string
+	FTCreator string
+	FTCount   int32
+}
+
+// Covers tells whether payment buys credit credits at price p
+func (p Price) Covers(payment ft.Payment, credit uint) bool {
+	if credit == 0 || p.Receiver == "" || p.FTCount <= 0 || payment.FTCount <= 0 {
+		return false
+	}
+	if payment.Receiver != p.Receiver || payment.FTName != p.FTName {
+		return false
+	}
+	if p.FTCreator != "" && payment.CreatorDID != p.FTCreator {
+		return false
+	}
+
+	return credit <= uint(payment.FTCount) && int64(credit)*int64(p.FTCount) <= int64(payment.FTCount)
+}
+
+// DoAddCredit adds credits to the ledger for a payment made earlier by the
+// same execution, through do_transfer_ft_trie, by the DID being credited.
+// Credits cannot be added without such a payment, which must be made to the
+// receiver of the price and cover the credits, and each payment buys credits
+// once.
 type DoAddCredit struct {
 	allocFunc *wasmtime.Func
 	memory    *wasmtime.Memory
+	ledger    *Ledger
+	payments  *ft.Payments
+	price     Price
+	onGrant   func(grant Grant)
 }
 
-func NewDoAddCredit() *DoAddCredit {
-	return &DoAddCredit{}
+// NewDoAddCredit calls onGrant, if not nil, for every grant written
+func NewDoAddCredit(ledger *Ledger, payments *ft.Payments, price Price, onGrant func(grant Grant)) *DoAddCredit {
+	return &DoAddCredit{ledger: ledger, payments: payments, price: price, onGrant: onGrant}
 }
 
 func (h *DoAddCredit) Name() string {
@@ -47,7 +92,21 @@ type AddCreditData struct {
 	Credit  float64 `json:"credit"`
 }
 
+// credits returns the number of credits requested. Credits are bought whole,
+// so that no payment is claimed for a fraction of a credit rounded down to
+// nothing.
+func (d AddCreditData) credits() (uint, error) {
+	if d.UserDid == "" || d.Credit < 1 || d.Credit > math.MaxUint32 || d.Credit != math.Trunc(d.Credit) {
+		return 0, fmt.Errorf("invalid credit of %v for DID %q, a whole number of credits is required", d.Credit, d.UserDid)
+	}
+	return uint(d.Credit), nil
+}
+
 func (h *DoAddCredit) callback(caller *wasmtime.Caller, args []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
+	if h.ledger == nil || h.payments == nil {
+		return utils.HandleError("credit ledger for DoAddCredit is not initialized")
+	}
+
 	inputArgs, _ := utils.HostFunctionParamExtraction(args, true, false)
 
 	// Extract input bytes
@@ -66,6 +125,34 @@ func (h *DoAddCredit) callback(caller *wasmtime.Caller, args []wasmtime.Val) ([]
 		errMsg := "Error unmarshaling response in callback function:" + err.Error()
 		return utils.HandleError(errMsg)
 	}
+	credit, err := addCreditData.credits()
+	if err != nil {
+		return utils.HandleError(err.Error())
+	}
+
+	if h.price.Receiver == "" {
+		return utils.HandleError("credits are not sold, no receiver of the payments is configured")
+	}
+
+	payment, ok := h.payments.Claim(addCreditData.UserDid, func(payment ft.Payment) bool {
+		return h.price.Covers(payment, credit)
+	})
+	if !ok {
+		return utils.HandleError(fmt.Sprintf("no payment by %v of %d %v to %v was made by the contract to buy %v credits", addCreditData.UserDid, int64(credit)*int64(h.price.FTCount), h.price.FTName, h.price.Receiver, credit))
+	}
+
+	grant := Grant{
+		UserDid: addCreditData.UserDid,
+		Credit:  credit,
+		TxID:    payment.TxID,
+	}
+	if err := h.ledger.AddForPayment(grant.UserDid, grant.Credit, grant.TxID); err != nil {
+		fmt.Println("Failed to add credits", err)
+		return utils.HandleError(err.Error())
+	}
+	if h.onGrant != nil {
+		h.onGrant(grant)
+	}
 
 	return utils.HandleOk()
 }
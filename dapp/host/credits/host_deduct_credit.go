@@ -0,0 +1,164 @@
+package credits
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+)
+
+// CODE_INSUFFICIENT_CREDITS is returned to the contract when the balance is
+// too low for a deduction. It follows the codes of the wallet commands.
+const CODE_INSUFFICIENT_CREDITS int32 = 5
+
+// OUTCOME_INSUFFICIENT_CREDITS is the outcome written to the output of the
+// contract along with CODE_INSUFFICIENT_CREDITS
+const OUTCOME_INSUFFICIENT_CREDITS = "insufficient_credits"
+
+// DoDeductCredit debits the credits of the initiator of the execution from
+// the ledger, so that a contract charging for inference or asset usage takes
+// the credits in the same run as the event it records. When the balance is
+// too low, nothing is debited and CODE_INSUFFICIENT_CREDITS is returned, so
+// that the contract can tell the user to buy credits.
+type DoDeductCredit struct {
+	allocFunc    *wasmtime.Func
+	memory       *wasmtime.Memory
+	ledger       *Ledger
+	initiatorDID string
+	onDeduct     func(deduction Deduction)
+}
+
+// NewDoDeductCredit only deducts the credits of initiatorDID, and calls
+// onDeduct, if not nil, for every deduction written, so that the credits can
+// be refunded if the contract fails afterwards
+func NewDoDeductCredit(ledger *Ledger, initiatorDID string, onDeduct func(deduction Deduction)) *DoDeductCredit {
+	return &DoDeductCredit{ledger: ledger, initiatorDID: initiatorDID, onDeduct: onDeduct}
+}
+
+func (h *DoDeductCredit) Name() string {
+	return "do_deduct_credit"
+}
+
+func (h *DoDeductCredit) FuncType() *wasmtime.FuncType {
+	return wasmtime.NewFuncType(
+		[]*wasmtime.ValType{
+			wasmtime.NewValType(wasmtime.KindI32), // input_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // input_len
+			wasmtime.NewValType(wasmtime.KindI32), // resp_ptr_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // resp_len_ptr
+		},
+		[]*wasmtime.ValType{wasmtime.NewValType(wasmtime.KindI32)}, // return i32
+	)
+}
+
+func (h *DoDeductCredit) Initialize(allocFunc, deallocFunc *wasmtime.Func, memory *wasmtime.Memory, nodeAddress string, quorumType int, wasmContext *context.WasmContext) {
+	h.allocFunc = allocFunc
+	h.memory = memory
+}
+
+func (h *DoDeductCredit) Callback() host.HostFunctionCallBack {
+	return h.callback
+}
+
+type DeductCreditData struct {
+	UserDid string `json:"user_did"`
+	Amount  uint   `json:"amount"`
+	Reason  string `json:"reason"`
+	AssetID string `json:"asset_id"`
+}
+
+// Deduction is the credits taken from a DID by a contract
+type Deduction struct {
+	UserDid string `json:"user_did"`
+	Amount  uint   `json:"amount"`
+	Reason  string `json:"reason"`
+	AssetID string `json:"asset_id"`
+}
+
+type CreditDeduction struct {
+	UserDid  string `json:"user_did"`
+	Deducted uint   `json:"deducted"`
+	Credit   uint   `json:"credit"`
+}
+
+func (h *DoDeductCredit) callback(caller *wasmtime.Caller, args []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
+	if h.ledger == nil {
+		return utils.HandleError("credit ledger for DoDeductCredit is not initialized")
+	}
+
+	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
+
+	inputBytes, memory, err := utils.ExtractDataFromWASM(caller, inputArgs)
+	if err != nil {
+		fmt.Println("Failed to extract data from WASM", err)
+		return utils.HandleError(err.Error())
+	}
+	h.memory = memory // Assign memory to Host struct for future use
+
+	var deductData DeductCreditData
+	if err := json.Unmarshal(inputBytes, &deductData); err != nil {
+		fmt.Println("Error unmarshaling response in callback function:", err)
+		return utils.HandleError("Error unmarshaling response in callback function:" + err.Error())
+	}
+	if deductData.UserDid == "" || deductData.Amount == 0 {
+		return utils.HandleError("user_did and a non-zero amount are required to deduct credits")
+	}
+	if deductData.Reason == "" {
+		return utils.HandleError("reason is required to deduct credits")
+	}
+	if deductData.UserDid != h.initiatorDID {
+		return utils.HandleError(fmt.Sprintf("credits of %v cannot be deducted by a contract initiated by %v", deductData.UserDid, h.initiatorDID))
+	}
+
+	creditInfo, err := h.ledger.Deduct(deductData.UserDid, deductData.Amount)
+	if errors.Is(err, ErrInsufficientCredits) {
+		return handleInsufficientCredits(caller, h.allocFunc, outputArgs, err)
+	}
+	if err != nil {
+		fmt.Println("failed to deduct credits", err)
+		return utils.HandleError(fmt.Sprintf("failed to deduct credits for %v, err: %v", deductData.Reason, err))
+	}
+	if h.onDeduct != nil {
+		h.onDeduct(Deduction{
+			UserDid: deductData.UserDid,
+			Amount:  deductData.Amount,
+			Reason:  deductData.Reason,
+			AssetID: deductData.AssetID,
+		})
+	}
+
+	responseBytes, _ := json.Marshal(&CreditDeduction{
+		UserDid:  deductData.UserDid,
+		Deducted: deductData.Amount,
+		Credit:   creditInfo.Credit,
+	})
+
+	err = utils.UpdateDataToWASM(caller, h.allocFunc, string(responseBytes), outputArgs)
+	if err != nil {
+		fmt.Println("Failed to update data to WASM", err)
+		return utils.HandleError(err.Error())
+	}
+
+	return utils.HandleOk()
+}
+
+// handleInsufficientCredits writes the outcome of a deduction refused for
+// lack of credits to the output of the contract, as wallet.HandleCommandError
+// does for the commands the wallet does not approve
+func handleInsufficientCredits(caller *wasmtime.Caller, allocFunc *wasmtime.Func, outputArgs *utils.WasmArgInfo, err error) ([]wasmtime.Val, *wasmtime.Trap) {
+	outcomeBytes, _ := json.Marshal(map[string]interface{}{
+		"outcome": OUTCOME_INSUFFICIENT_CREDITS,
+		"code":    CODE_INSUFFICIENT_CREDITS,
+		"error":   err.Error(),
+	})
+	if err := utils.UpdateDataToWASM(caller, allocFunc, string(outcomeBytes), outputArgs); err != nil {
+		fmt.Println("Failed to update data to WASM", err)
+		return utils.HandleError(err.Error())
+	}
+
+	return []wasmtime.Val{wasmtime.ValI32(CODE_INSUFFICIENT_CREDITS)}, nil
+}
@@ -0,0 +1,99 @@
+package credits
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+)
+
+// DoGetCreditBalance reads the credit balance of a DID from the ledger, so
+// that contracts can check it before charging the DID
+type DoGetCreditBalance struct {
+	allocFunc *wasmtime.Func
+	memory    *wasmtime.Memory
+	ledger    *Ledger
+}
+
+func NewDoGetCreditBalance(ledger *Ledger) *DoGetCreditBalance {
+	return &DoGetCreditBalance{ledger: ledger}
+}
+
+func (h *DoGetCreditBalance) Name() string {
+	return "do_get_credit_balance"
+}
+
+func (h *DoGetCreditBalance) FuncType() *wasmtime.FuncType {
+	return wasmtime.NewFuncType(
+		[]*wasmtime.ValType{
+			wasmtime.NewValType(wasmtime.KindI32), // input_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // input_len
+			wasmtime.NewValType(wasmtime.KindI32), // resp_ptr_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // resp_len_ptr
+		},
+		[]*wasmtime.ValType{wasmtime.NewValType(wasmtime.KindI32)}, // return i32
+	)
+}
+
+func (h *DoGetCreditBalance) Initialize(allocFunc, deallocFunc *wasmtime.Func, memory *wasmtime.Memory, nodeAddress string, quorumType int, wasmContext *context.WasmContext) {
+	h.allocFunc = allocFunc
+	h.memory = memory
+}
+
+func (h *DoGetCreditBalance) Callback() host.HostFunctionCallBack {
+	return h.callback
+}
+
+type GetCreditBalanceData struct {
+	UserDid string `json:"user_did"`
+}
+
+type CreditBalance struct {
+	UserDid string `json:"user_did"`
+	Credit  uint   `json:"credit"`
+}
+
+func (h *DoGetCreditBalance) callback(caller *wasmtime.Caller, args []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
+	if h.ledger == nil {
+		return utils.HandleError("credit ledger for DoGetCreditBalance is not initialized")
+	}
+
+	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
+
+	inputBytes, memory, err := utils.ExtractDataFromWASM(caller, inputArgs)
+	if err != nil {
+		fmt.Println("Failed to extract data from WASM", err)
+		return utils.HandleError(err.Error())
+	}
+	h.memory = memory // Assign memory to Host struct for future use
+
+	var balanceData GetCreditBalanceData
+	if err := json.Unmarshal(inputBytes, &balanceData); err != nil {
+		fmt.Println("Error unmarshaling response in callback function:", err)
+		return utils.HandleError("Error unmarshaling response in callback function:" + err.Error())
+	}
+	if balanceData.UserDid == "" {
+		return utils.HandleError("user_did is required to get the credit balance")
+	}
+
+	creditInfo, err := h.ledger.Balance(balanceData.UserDid)
+	if err != nil {
+		return utils.HandleError(err.Error())
+	}
+
+	responseBytes, _ := json.Marshal(&CreditBalance{
+		UserDid: balanceData.UserDid,
+		Credit:  creditInfo.Credit,
+	})
+
+	err = utils.UpdateDataToWASM(caller, h.allocFunc, string(responseBytes), outputArgs)
+	if err != nil {
+		fmt.Println("Failed to update data to WASM", err)
+		return utils.HandleError(err.Error())
+	}
+
+	return utils.HandleOk()
+}
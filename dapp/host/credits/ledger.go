@@ -0,0 +1,179 @@
+package credits
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// PAYMENT_KEY_PREFIX prefixes the keys recording the payments which were
+// credited, next to the balances keyed by DID
+const PAYMENT_KEY_PREFIX = "payment:"
+
+var ErrInsufficientCredits = errors.New("insufficient credits")
+
+type CreditInfo struct {
+	Credit    uint   `json:"credit"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Ledger keeps the inference credits of every DID in leveldb, keyed by DID.
+// It is shared by the credit endpoints and the host functions of the
+// contracts, so balances are updated under a lock.
+type Ledger struct {
+	mu     sync.Mutex
+	db     *leveldb.DB
+	dryRun bool
+}
+
+func NewLedger(db *leveldb.DB) *Ledger {
+	return &Ledger{db: db}
+}
+
+// DryRun returns a view of the ledger for the dry runs of contracts, which
+// reads the balances but checks updates without writing them
+func (l *Ledger) DryRun() *Ledger {
+	return &Ledger{db: l.db, dryRun: true}
+}
+
+// Balance returns the credits of did, which has none until it buys some
+func (l *Ledger) Balance(did string) (*CreditInfo, error) {
+	creditInfoBytes, err := l.db.Get([]byte(did), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return &CreditInfo{Credit: 0, Timestamp: ""}, nil
+		}
+		return nil, fmt.Errorf("failed to get credit balance for DID %s: %v", did, err)
+	}
+
+	var creditInfo *CreditInfo
+	err = json.Unmarshal(creditInfoBytes, &creditInfo)
+	if err != nil {
+		return nil, err // Error unmarshaling the balance
+	}
+
+	return creditInfo, nil
+}
+
+// AddForPayment adds creditCount credits to the balance of did, paid for by
+// the FT transfer txID. The balance and the payment are written in a single
+// batch, and a payment is never credited twice.
+func (l *Ledger) AddForPayment(did string, creditCount uint, txID string) error {
+	if txID == "" {
+		return fmt.Errorf("credits for DID %s must be paid for by a transaction", did)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	paymentKey := []byte(PAYMENT_KEY_PREFIX + txID)
+	credited, err := l.db.Has(paymentKey, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check payment %s, err: %v", txID, err)
+	}
+	if credited {
+		return fmt.Errorf("payment %s was already credited", txID)
+	}
+
+	creditInfo, err := l.Balance(did)
+	if err != nil {
+		return fmt.Errorf("failed to get existing credit balance for DID %s: %v", did, err)
+	}
+
+	creditInfo.Credit += creditCount
+	creditInfo.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+
+	creditInfoBytes, err := json.Marshal(creditInfo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credit info: %v", err)
+	}
+	paymentBytes, err := json.Marshal(map[string]interface{}{
+		"did":       did,
+		"credit":    creditCount,
+		"timestamp": creditInfo.Timestamp,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment %s: %v", txID, err)
+	}
+
+	if l.dryRun {
+		return nil
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(did), creditInfoBytes)
+	batch.Put(paymentKey, paymentBytes)
+	if err := l.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("failed to add credits for DID %s: %v", did, err)
+	}
+
+	return nil
+}
+
+// Deduct takes amount credits from the balance of did, and returns the new
+// balance. It fails with ErrInsufficientCredits, leaving the balance as it
+// is, when the balance is lower than amount.
+func (l *Ledger) Deduct(did string, amount uint) (*CreditInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	creditInfo, err := l.Balance(did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing credit balance for DID %s: %v", did, err)
+	}
+	if creditInfo.Credit < amount {
+		return nil, fmt.Errorf("%w: DID %s has %d credits, %d required", ErrInsufficientCredits, did, creditInfo.Credit, amount)
+	}
+
+	creditInfo.Credit -= amount
+	creditInfo.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+
+	if l.dryRun {
+		return creditInfo, nil
+	}
+
+	if err := l.put(did, creditInfo); err != nil {
+		return nil, fmt.Errorf("failed to deduct credits for DID %s: %v", did, err)
+	}
+
+	return creditInfo, nil
+}
+
+// Refund gives amount credits back to did, deducted by a contract which
+// failed afterwards, and returns the new balance
+func (l *Ledger) Refund(did string, amount uint) (*CreditInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	creditInfo, err := l.Balance(did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing credit balance for DID %s: %v", did, err)
+	}
+
+	creditInfo.Credit += amount
+	creditInfo.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+
+	if l.dryRun {
+		return creditInfo, nil
+	}
+
+	if err := l.put(did, creditInfo); err != nil {
+		return nil, fmt.Errorf("failed to refund credits for DID %s: %v", did, err)
+	}
+
+	return creditInfo, nil
+}
+
+func (l *Ledger) put(did string, creditInfo *CreditInfo) error {
+	creditInfoBytes, err := json.Marshal(creditInfo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credit info: %v", err)
+	}
+
+	return l.db.Put([]byte(did), creditInfoBytes, nil)
+}
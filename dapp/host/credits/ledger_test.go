@@ -0,0 +1,258 @@
+package credits
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+
+	"dapp/host/ft"
+)
+
+func newTestLedger(t *testing.T) *Ledger {
+	t.Helper()
+
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return NewLedger(db)
+}
+
+func balanceOf(t *testing.T, ledger *Ledger, did string) uint {
+	t.Helper()
+
+	creditInfo, err := ledger.Balance(did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creditInfo.Credit
+}
+
+func TestLedgerAddForPayment(t *testing.T) {
+	type grant struct {
+		did    string
+		credit uint
+		txID   string
+		ok     bool
+	}
+
+	tests := []struct {
+		name    string
+		dryRun  bool
+		grants  []grant
+		balance uint
+	}{
+		{
+			name:    "payments are credited",
+			grants:  []grant{{"did", 10, "tx1", true}, {"did", 5, "tx2", true}},
+			balance: 15,
+		},
+		{
+			name:    "a payment is credited once",
+			grants:  []grant{{"did", 10, "tx1", true}, {"did", 10, "tx1", false}},
+			balance: 10,
+		},
+		{
+			name:    "a payment credits a single DID",
+			grants:  []grant{{"other", 10, "tx1", true}, {"did", 10, "tx1", false}},
+			balance: 0,
+		},
+		{
+			name:    "credits must be paid for",
+			grants:  []grant{{"did", 10, "", false}},
+			balance: 0,
+		},
+		{
+			name:    "dry runs write nothing",
+			dryRun:  true,
+			grants:  []grant{{"did", 10, "tx1", true}, {"did", 10, "tx1", true}},
+			balance: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := newTestLedger(t)
+			writer := ledger
+			if tt.dryRun {
+				writer = ledger.DryRun()
+			}
+
+			for _, g := range tt.grants {
+				err := writer.AddForPayment(g.did, g.credit, g.txID)
+				if (err == nil) != g.ok {
+					t.Fatalf("AddForPayment(%v, %d, %q) error = %v, want ok %v", g.did, g.credit, g.txID, err, g.ok)
+				}
+			}
+
+			if balance := balanceOf(t, ledger, "did"); balance != tt.balance {
+				t.Fatalf("balance = %d, want %d", balance, tt.balance)
+			}
+		})
+	}
+}
+
+func TestPriceCovers(t *testing.T) {
+	price := Price{Receiver: "dapp", FTName: "TRIE", FTCount: 2}
+	payment := ft.Payment{TxID: "tx", Sender: "did", Receiver: "dapp", FTName: "TRIE", CreatorDID: "creator", FTCount: 10}
+
+	tests := []struct {
+		name    string
+		price   Price
+		payment func(p ft.Payment) ft.Payment
+		credit  uint
+		covers  bool
+	}{
+		{
+			name:   "payment covering the credits",
+			price:  price,
+			credit: 5,
+			covers: true,
+		},
+		{
+			name:   "payment below the price",
+			price:  price,
+			credit: 6,
+		},
+		{
+			name:  "payment to another DID",
+			price: price,
+			payment: func(p ft.Payment) ft.Payment {
+				p.Receiver = "other"
+				return p
+			},
+			credit: 1,
+		},
+		{
+			name:  "payment in another FT",
+			price: price,
+			payment: func(p ft.Payment) ft.Payment {
+				p.FTName = "OTHER"
+				return p
+			},
+			credit: 1,
+		},
+		{
+			name:   "FT minted by the expected creator",
+			price:  Price{Receiver: "dapp", FTName: "TRIE", FTCreator: "creator", FTCount: 2},
+			credit: 1,
+			covers: true,
+		},
+		{
+			name:   "FT minted by another creator",
+			price:  Price{Receiver: "dapp", FTName: "TRIE", FTCreator: "other", FTCount: 2},
+			credit: 1,
+		},
+		{
+			name:   "credits not sold",
+			price:  Price{FTName: "TRIE", FTCount: 2},
+			credit: 1,
+		},
+		{
+			name:   "credits too many to be priced",
+			price:  price,
+			credit: ^uint(0),
+		},
+		{
+			name:   "no credits",
+			price:  price,
+			credit: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := payment
+			if tt.payment != nil {
+				p = tt.payment(p)
+			}
+			if covers := tt.price.Covers(p, tt.credit); covers != tt.covers {
+				t.Fatalf("Covers(%+v, %d) = %v, want %v", p, tt.credit, covers, tt.covers)
+			}
+		})
+	}
+}
+
+func TestLedgerDeduct(t *testing.T) {
+	tests := []struct {
+		name    string
+		dryRun  bool
+		amount  uint
+		refund  bool
+		err     error
+		left    uint
+		balance uint
+	}{
+		{name: "part of the balance", amount: 4, left: 6, balance: 6},
+		{name: "whole balance", amount: 10, left: 0, balance: 0},
+		{name: "more than the balance", amount: 11, err: ErrInsufficientCredits, balance: 10},
+		{name: "refunded", amount: 4, refund: true, left: 6, balance: 10},
+		{name: "dry run", dryRun: true, amount: 4, left: 6, balance: 10},
+		{name: "dry run beyond the balance", dryRun: true, amount: 11, err: ErrInsufficientCredits, balance: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := newTestLedger(t)
+			if err := ledger.AddForPayment("did", 10, "tx"); err != nil {
+				t.Fatal(err)
+			}
+			writer := ledger
+			if tt.dryRun {
+				writer = ledger.DryRun()
+			}
+
+			creditInfo, err := writer.Deduct("did", tt.amount)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Deduct(%d) error = %v, want %v", tt.amount, err, tt.err)
+			}
+			if err == nil && creditInfo.Credit != tt.left {
+				t.Fatalf("Deduct(%d) left %d credits, want %d", tt.amount, creditInfo.Credit, tt.left)
+			}
+
+			if tt.refund {
+				if _, err := writer.Refund("did", tt.amount); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if balance := balanceOf(t, ledger, "did"); balance != tt.balance {
+				t.Fatalf("balance = %d, want %d", balance, tt.balance)
+			}
+		})
+	}
+}
+
+func TestAddCreditDataCredits(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   AddCreditData
+		credit uint
+		err    bool
+	}{
+		{name: "whole credits", data: AddCreditData{UserDid: "did", Credit: 3}, credit: 3},
+		{name: "whole credits sent as a float", data: AddCreditData{UserDid: "did", Credit: 1.0}, credit: 1},
+		{name: "fraction of a credit", data: AddCreditData{UserDid: "did", Credit: 0.5}, err: true},
+		{name: "fractional credits", data: AddCreditData{UserDid: "did", Credit: 2.5}, err: true},
+		{name: "no credits", data: AddCreditData{UserDid: "did", Credit: 0}, err: true},
+		{name: "negative credits", data: AddCreditData{UserDid: "did", Credit: -1}, err: true},
+		{name: "credits too many to be stored", data: AddCreditData{UserDid: "did", Credit: 1 << 40}, err: true},
+		{name: "no DID", data: AddCreditData{Credit: 1}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credit, err := tt.data.credits()
+			if (err != nil) != tt.err {
+				t.Fatalf("credits() error = %v, want error %v", err, tt.err)
+			}
+			if credit != tt.credit {
+				t.Fatalf("credits() = %d, want %d", credit, tt.credit)
+			}
+		})
+	}
+}
@@ -5,10 +5,11 @@ import (
 	"fmt"
 
 	"github.com/bytecodealliance/wasmtime-go"
-	"github.com/gorilla/websocket"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+
+	"dapp/wallet"
 )
 
 type CreateFTData struct {
@@ -24,11 +25,11 @@ type DoCreateFTApiCall struct {
 	memory      *wasmtime.Memory
 	nodeAddress string
 	quorumType  int
-	wasmCtx     *context.WasmContext
+	signer      wallet.Signer
 }
 
-func NewDoCreateFTApiCall() *DoCreateFTApiCall {
-	return &DoCreateFTApiCall{}
+func NewDoCreateFTApiCall(signer wallet.Signer) *DoCreateFTApiCall {
+	return &DoCreateFTApiCall{signer: signer}
 }
 
 func (h *DoCreateFTApiCall) Name() string {
@@ -52,42 +53,19 @@ func (h *DoCreateFTApiCall) Initialize(allocFunc, deallocFunc *wasmtime.Func, me
 	h.memory = memory
 	h.nodeAddress = nodeAddress
 	h.quorumType = quorumType
-	h.wasmCtx = wasmContext
 }
 
 func (h *DoCreateFTApiCall) Callback() host.HostFunctionCallBack {
 	return h.callback
 }
 
-func callCreateFTAPI(webSocketConn *websocket.Conn, nodeAddress string, quorumType int, createFTdata CreateFTData) error {
+func callCreateFTAPI(signer wallet.Signer, quorumType int, createFTdata CreateFTData) error {
 	fmt.Println("LOG: call from contract to do Create FT")
 	createFTdata.QuorumType = int32(quorumType)
 
-	createFTdataBytes, _ := json.Marshal(createFTdata)
-	var createFTDataMap map[string]interface{} = make(map[string]interface{})
-
-	if err := json.Unmarshal(createFTdataBytes, &createFTDataMap); err != nil {
-		return fmt.Errorf("error unmarshalling createFTdataBytes: %v", err)
-	}
-
-	msgPayload := map[string]interface{}{
-		"type": "OPEN_EXTENSION",
-		"data": &ExtensionCommand{
-			Action:  "CREATE_FT",
-			Payload: createFTDataMap,
-		},
-	}
-
-	msgPayloadBytes, _ := json.Marshal(msgPayload)
-
-	err := webSocketConn.WriteMessage(websocket.TextMessage, msgPayloadBytes)
-	if err != nil {
-		return fmt.Errorf("error occured while invoking FT create, err: %v", err)
-	}
-
-	_, resp, err := webSocketConn.ReadMessage()
+	resp, err := signer.Send("CREATE_FT", createFTdata)
 	if err != nil {
-		return fmt.Errorf("unable to read response from web socket connection for FT Create, err: %v", err)
+		return fmt.Errorf("error occured while invoking FT create, err: %w", err)
 	}
 
 	var response *BasicResponse
@@ -109,7 +87,9 @@ func (h *DoCreateFTApiCall) callback(
 	caller *wasmtime.Caller,
 	args []wasmtime.Val,
 ) ([]wasmtime.Val, *wasmtime.Trap) {
-	trieServerSocketConn := h.wasmCtx.ExternalSocketConn()
+	if h.signer == nil {
+		return utils.HandleError("wallet signer for DoCreateFTApiCall is not initialized")
+	}
 
 	// Validate the number of arguments
 	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
@@ -130,12 +110,11 @@ func (h *DoCreateFTApiCall) callback(
 		errMsg := "Error unmarshalling response in callback function" + err3.Error()
 		return utils.HandleError(errMsg)
 	}
-	callCreateFTAPIRespErr := callCreateFTAPI(trieServerSocketConn, h.nodeAddress, h.quorumType, createFTData)
+	callCreateFTAPIRespErr := callCreateFTAPI(h.signer, h.quorumType, createFTData)
 
 	if callCreateFTAPIRespErr != nil {
 		fmt.Println("failed to create FT", callCreateFTAPIRespErr)
-		errMsg := "failed to create FT" + callCreateFTAPIRespErr.Error()
-		return utils.HandleError(errMsg)
+		return wallet.HandleCommandError(caller, h.allocFunc, outputArgs, fmt.Errorf("failed to create FT, err: %w", callCreateFTAPIRespErr))
 	}
 
 	responseStr := "success"
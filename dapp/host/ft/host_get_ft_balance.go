@@ -0,0 +1,169 @@
+package ft
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+)
+
+// FT_INFO_TIMEOUT bounds the request of the FT balances to the Rubix node
+const FT_INFO_TIMEOUT = 30 * time.Second
+
+type GetFTBalanceData struct {
+	DID        string `json:"did"`
+	FTName     string `json:"ft_name"`
+	CreatorDID string `json:"creatorDID"`
+}
+
+type FTBalance struct {
+	DID     string `json:"did"`
+	FTName  string `json:"ft_name"`
+	FTCount int    `json:"ft_count"`
+}
+
+// ftInfoResponse is the response of the /api/get-ft-info-by-did node API
+type ftInfoResponse struct {
+	BasicResponse
+	FTInfo []struct {
+		FTName     string `json:"ft_name"`
+		FTCount    int    `json:"ft_count"`
+		CreatorDID string `json:"creator_did"`
+	} `json:"ft_info"`
+}
+
+// DoGetFTBalance reads the number of FTs of a given name held by a DID from
+// the Rubix node, so that contracts can check it before a transfer. It needs
+// no wallet approval.
+type DoGetFTBalance struct {
+	allocFunc   *wasmtime.Func
+	memory      *wasmtime.Memory
+	nodeAddress string
+}
+
+func NewDoGetFTBalance() *DoGetFTBalance {
+	return &DoGetFTBalance{}
+}
+
+func (h *DoGetFTBalance) Name() string {
+	return "do_get_ft_balance"
+}
+
+func (h *DoGetFTBalance) FuncType() *wasmtime.FuncType {
+	return wasmtime.NewFuncType(
+		[]*wasmtime.ValType{
+			wasmtime.NewValType(wasmtime.KindI32), // input_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // input_len
+			wasmtime.NewValType(wasmtime.KindI32), // resp_ptr_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // resp_len_ptr
+		},
+		[]*wasmtime.ValType{wasmtime.NewValType(wasmtime.KindI32)}, // return i32
+	)
+}
+
+func (h *DoGetFTBalance) Initialize(allocFunc, deallocFunc *wasmtime.Func, memory *wasmtime.Memory, nodeAddress string, quorumType int, wasmContext *context.WasmContext) {
+	h.allocFunc = allocFunc
+	h.memory = memory
+	h.nodeAddress = nodeAddress
+}
+
+func (h *DoGetFTBalance) Callback() host.HostFunctionCallBack {
+	return h.callback
+}
+
+// callGetFTInfoAPI returns the number of FTs named ftName held by did. FTs
+// of that name minted by another creator than creatorDID are not counted,
+// unless creatorDID is empty.
+func callGetFTInfoAPI(nodeAddress string, did string, ftName string, creatorDID string) (int, error) {
+	targetURL, err := url.JoinPath(nodeAddress, "/api/get-ft-info-by-did")
+	if err != nil {
+		return 0, fmt.Errorf("failed to construct URL: %w", err)
+	}
+	targetURL += "?did=" + url.QueryEscape(did)
+
+	client := &http.Client{Timeout: FT_INFO_TIMEOUT}
+	resp, err := client.Get(targetURL)
+	if err != nil {
+		return 0, fmt.Errorf("GET request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var response ftInfoResponse
+	if err := json.Unmarshal(respBytes, &response); err != nil {
+		return 0, fmt.Errorf("unable to unmarshal the FT info of %v, err: %v", did, err)
+	}
+	if !response.Status {
+		return 0, fmt.Errorf("unable to get the FT info of %v: %s", did, response.Message)
+	}
+
+	balance := 0
+	for _, info := range response.FTInfo {
+		if info.FTName != ftName {
+			continue
+		}
+		if creatorDID != "" && info.CreatorDID != creatorDID {
+			continue
+		}
+		balance += info.FTCount
+	}
+
+	return balance, nil
+}
+
+func (h *DoGetFTBalance) callback(
+	caller *wasmtime.Caller,
+	args []wasmtime.Val,
+) ([]wasmtime.Val, *wasmtime.Trap) {
+	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
+
+	inputBytes, memory, err := utils.ExtractDataFromWASM(caller, inputArgs)
+	if err != nil {
+		fmt.Println("Failed to extract data from WASM", err)
+		return utils.HandleError(err.Error())
+	}
+	h.memory = memory // Assign memory to Host struct for future use
+
+	var balanceData GetFTBalanceData
+	if err := json.Unmarshal(inputBytes, &balanceData); err != nil {
+		fmt.Println("Error unmarshaling response in callback function:", err)
+		return utils.HandleError("Error unmarshaling response in callback function:" + err.Error())
+	}
+	if balanceData.DID == "" || balanceData.FTName == "" {
+		return utils.HandleError("did and ft_name are required to get the FT balance")
+	}
+
+	ftCount, err := callGetFTInfoAPI(h.nodeAddress, balanceData.DID, balanceData.FTName, balanceData.CreatorDID)
+	if err != nil {
+		fmt.Println("failed to get FT balance", err)
+		return utils.HandleError(fmt.Sprintf("failed to get FT balance, err: %v", err))
+	}
+
+	responseBytes, _ := json.Marshal(&FTBalance{
+		DID:     balanceData.DID,
+		FTName:  balanceData.FTName,
+		FTCount: ftCount,
+	})
+
+	err = utils.UpdateDataToWASM(caller, h.allocFunc, string(responseBytes), outputArgs)
+	if err != nil {
+		fmt.Println("Failed to update data to WASM", err)
+		return utils.HandleError(err.Error())
+	}
+
+	return utils.HandleOk()
+}
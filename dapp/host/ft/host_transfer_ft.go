@@ -3,12 +3,15 @@ package ft
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/bytecodealliance/wasmtime-go"
-	"github.com/gorilla/websocket"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+
+	"dapp/events"
+	"dapp/wallet"
 )
 
 type TransferFTData struct {
@@ -21,16 +24,24 @@ type TransferFTData struct {
 	Sender     string `json:"sender"`
 }
 
+// HOSTING_FEE_COMMENT_PREFIX marks the transfers paying the hosting fee of
+// an asset to its DePin provider, which are commented with "nft:<asset ID>"
+const HOSTING_FEE_COMMENT_PREFIX = "nft:"
+
 type DoTransferFTApiCall struct {
 	allocFunc   *wasmtime.Func
 	memory      *wasmtime.Memory
 	nodeAddress string
 	quorumType  int
-	wasmCtx     *context.WasmContext
+	signer      wallet.Signer
+	publisher   events.Publisher
+	payments    *Payments
 }
 
-func NewDoTransferFTApiCall() *DoTransferFTApiCall {
-	return &DoTransferFTApiCall{}
+// NewDoTransferFTApiCall records the transfers it carries out in payments,
+// which may be nil when nothing is paid for within the contract
+func NewDoTransferFTApiCall(signer wallet.Signer, publisher events.Publisher, payments *Payments) *DoTransferFTApiCall {
+	return &DoTransferFTApiCall{signer: signer, publisher: publisher, payments: payments}
 }
 func (h *DoTransferFTApiCall) Name() string {
 	return "do_transfer_ft_trie"
@@ -52,47 +63,21 @@ func (h *DoTransferFTApiCall) Initialize(allocFunc, deallocFunc *wasmtime.Func,
 	h.memory = memory
 	h.nodeAddress = nodeAddress
 	h.quorumType = quorumType
-	h.wasmCtx = wasmContext
 }
 
 func (h *DoTransferFTApiCall) Callback() host.HostFunctionCallBack {
 	return h.callback
 }
-func callTransferFTAPI(webSocketConn *websocket.Conn, nodeAddress string, quorumType int, transferFTdata TransferFTData) error {
+
+// callTransferFTAPI sends the FT transfer to the wallet, and returns the ID
+// of the transaction along with whether the wallet reported it on its own
+func callTransferFTAPI(signer wallet.Signer, quorumType int, transferFTdata TransferFTData) (txID string, reported bool, err error) {
 	fmt.Println("LOG: call from contract to do Transfer FT")
 	transferFTdata.QuorumType = int32(quorumType)
 
-	transferFTdataBytes, _ := json.Marshal(transferFTdata)
-	var transferFTDataMap map[string]interface{} = make(map[string]interface{})
-
-	if err := json.Unmarshal(transferFTdataBytes, &transferFTDataMap); err != nil {
-		return fmt.Errorf("error unmarshalling transferFTdataBytes: %v", err)
-	}
-
-	// errDeadline := webSocketConn.SetReadDeadline(time.Now().Add(5 * time.Minute))
-	// if errDeadline != nil {
-	// 	return fmt.Errorf("error setting read deadline for web socket connection, err: %v", errDeadline)
-	// }
-
-	msgPayload := map[string]interface{}{
-		"type": "OPEN_EXTENSION",
-		"data": &ExtensionCommand{
-			Action:  "TRANSFER_FT",
-			Payload: transferFTDataMap,
-		},
-	}
-
-	msgPayloadBytes, _ := json.Marshal(msgPayload)
-
-	err := webSocketConn.WriteMessage(websocket.TextMessage, msgPayloadBytes)
-	if err != nil {
-		return fmt.Errorf("error occured while invoking FT transfer twice, err: %v", err)
-	}
-
-
-	_, resp, err := webSocketConn.ReadMessage()
+	resp, err := signer.Send("TRANSFER_FT", transferFTdata)
 	if err != nil {
-		return fmt.Errorf("unable to read response from web socket connection for FT Transfer, err: %v", err)
+		return "", false, fmt.Errorf("error occured while invoking FT transfer, err: %w", err)
 	}
 
 	fmt.Println("Response received for FT Transfer:", string(resp))
@@ -101,24 +86,37 @@ func callTransferFTAPI(webSocketConn *websocket.Conn, nodeAddress string, quorum
 	err3 := json.Unmarshal(resp, &response)
 	if err3 != nil {
 		fmt.Println("Error unmarshaling response:", err3)
-		return err3
+		return "", false, err3
 	}
 
 	fmt.Println("Response received for FT Transfer:", response)
 
 	if !response.Status {
-		fmt.Println("error in response for FT: %s", response.Message)
-		return fmt.Errorf("error in response for FT: %s", response.Message)
+		fmt.Printf("error in response for FT: %s\n", response.Message)
+		return "", false, fmt.Errorf("error in response for FT: %s", response.Message)
+	}
+
+	if response.TxID != "" {
+		return response.TxID, true, nil
+	}
+
+	// Wallets which do not report the transaction ID on its own leave it
+	// at the end of the message, as the Rubix node does
+	txID, err = wallet.TransactionIDFromMessage(response.Message)
+	if err != nil {
+		return "", false, fmt.Errorf("no transaction ID in the response for FT: %w", err)
 	}
 
-	return err3
+	return txID, false, nil
 }
 
 func (h *DoTransferFTApiCall) callback(
 	caller *wasmtime.Caller,
 	args []wasmtime.Val,
 ) ([]wasmtime.Val, *wasmtime.Trap) {
-	trieServerSocketConn := h.wasmCtx.ExternalSocketConn()
+	if h.signer == nil {
+		return utils.HandleError("wallet signer for DoTransferFTApiCall is not initialized")
+	}
 
 	// Validate the number of arguments
 	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
@@ -139,12 +137,25 @@ func (h *DoTransferFTApiCall) callback(
 		errMsg := "Error unmarshalling response in callback function" + err3.Error()
 		return utils.HandleError(errMsg)
 	}
-	callTransferFTAPIRespErr := callTransferFTAPI(trieServerSocketConn, h.nodeAddress, h.quorumType, transferFTData)
+	txID, reported, callTransferFTAPIRespErr := callTransferFTAPI(h.signer, h.quorumType, transferFTData)
 
 	if callTransferFTAPIRespErr != nil {
-		fmt.Println("failed to transfer NFT", callTransferFTAPIRespErr)
-		errMsg := "failed to transfer NFT" + callTransferFTAPIRespErr.Error()
-		return utils.HandleError(errMsg)
+		fmt.Println("failed to transfer FT", callTransferFTAPIRespErr)
+		return wallet.HandleCommandError(caller, h.allocFunc, outputArgs, fmt.Errorf("failed to transfer FT, err: %w", callTransferFTAPIRespErr))
+	}
+
+	// A transaction ID read from the message is not trusted to prove the
+	// payment, which would buy credits
+	if h.payments != nil && reported {
+		h.payments.Record(Payment{
+			TxID:       txID,
+			Sender:     transferFTData.Sender,
+			Receiver:   transferFTData.Receiver,
+			FTName:     transferFTData.FTName,
+			CreatorDID: transferFTData.CreatorDID,
+			FTCount:    transferFTData.FTCount,
+			Comment:    transferFTData.Comment,
+		})
 	}
 
 	responseStr := "success"
@@ -154,6 +165,17 @@ func (h *DoTransferFTApiCall) callback(
 		return utils.HandleError(err.Error())
 	}
 
+	if strings.HasPrefix(transferFTData.Comment, HOSTING_FEE_COMMENT_PREFIX) {
+		h.publisher.Publish(events.TopicHostingFeePaid, map[string]interface{}{
+			"asset_id":     strings.TrimPrefix(transferFTData.Comment, HOSTING_FEE_COMMENT_PREFIX),
+			"payer_did":    transferFTData.Sender,
+			"provider_did": transferFTData.Receiver,
+			"ft_name":      transferFTData.FTName,
+			"ft_count":     transferFTData.FTCount,
+			"tx_id":        txID,
+		}, transferFTData.Sender, transferFTData.Receiver)
+	}
+
 	return utils.HandleOk() // Success
 
 }
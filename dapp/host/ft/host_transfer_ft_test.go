@@ -0,0 +1,38 @@
+package ft
+
+import "testing"
+
+// replySigner answers every command with reply
+type replySigner string
+
+func (s replySigner) Send(action string, payload interface{}) ([]byte, error) {
+	return []byte(s), nil
+}
+
+func TestCallTransferFTAPI(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		txID     string
+		reported bool
+		err      bool
+	}{
+		{name: "transaction ID reported", reply: `{"status":true,"message":"Transfer finished successfully","tx_id":"tx"}`, txID: "tx", reported: true},
+		{name: "transaction ID in the message", reply: `{"status":true,"message":"Transfer finished successfully in 3s with tx"}`, txID: "tx"},
+		{name: "no transaction ID", reply: `{"status":true,"message":"done "}`, err: true},
+		{name: "empty message", reply: `{"status":true}`, err: true},
+		{name: "failed transfer with a transaction ID", reply: `{"status":false,"message":"insufficient balance","tx_id":"tx"}`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txID, reported, err := callTransferFTAPI(replySigner(tt.reply), 2, TransferFTData{FTCount: 1})
+			if (err != nil) != tt.err {
+				t.Fatalf("callTransferFTAPI() error = %v, want error %v", err, tt.err)
+			}
+			if txID != tt.txID || reported != tt.reported {
+				t.Fatalf("callTransferFTAPI() = %q (reported %v), want %q (reported %v)", txID, reported, tt.txID, tt.reported)
+			}
+		})
+	}
+}
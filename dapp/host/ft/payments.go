@@ -0,0 +1,52 @@
+package ft
+
+import "sync"
+
+// Payment is an FT transfer made by a contract execution
+type Payment struct {
+	TxID       string `json:"tx_id"`
+	Sender     string `json:"sender"`
+	Receiver   string `json:"receiver"`
+	FTName     string `json:"ft_name"`
+	CreatorDID string `json:"creator_did"`
+	FTCount    int32  `json:"ft_count"`
+	Comment    string `json:"comment"`
+}
+
+// Payments records the FT transfers made by an execution, so that the host
+// functions it calls afterwards can tell that it paid for what they grant.
+// Each payment is claimed at most once.
+type Payments struct {
+	mu       sync.Mutex
+	payments []Payment
+	claimed  map[string]bool
+}
+
+func NewPayments() *Payments {
+	return &Payments{
+		claimed: make(map[string]bool),
+	}
+}
+
+// Record adds a transfer approved by the wallet and carried out by the node
+func (p *Payments) Record(payment Payment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.payments = append(p.payments, payment)
+}
+
+// Claim returns the earliest payment made by sender which was not claimed
+// yet and is accepted, and marks it as claimed
+func (p *Payments) Claim(sender string, accept func(payment Payment) bool) (Payment, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, payment := range p.payments {
+		if payment.Sender == sender && !p.claimed[payment.TxID] && accept(payment) {
+			p.claimed[payment.TxID] = true
+			return payment, true
+		}
+	}
+	return Payment{}, false
+}
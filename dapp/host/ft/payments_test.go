@@ -0,0 +1,37 @@
+package ft
+
+import "testing"
+
+func TestPaymentsClaim(t *testing.T) {
+	payments := NewPayments()
+	payments.Record(Payment{TxID: "small", Sender: "did", Receiver: "dapp", FTCount: 1})
+	payments.Record(Payment{TxID: "other", Sender: "other", Receiver: "dapp", FTCount: 10})
+	payments.Record(Payment{TxID: "large", Sender: "did", Receiver: "dapp", FTCount: 10})
+
+	atLeast := func(count int32) func(Payment) bool {
+		return func(payment Payment) bool {
+			return payment.FTCount >= count
+		}
+	}
+
+	tests := []struct {
+		name   string
+		sender string
+		accept func(Payment) bool
+		txID   string
+	}{
+		{name: "earliest accepted payment of the sender", sender: "did", accept: atLeast(5), txID: "large"},
+		{name: "a payment is claimed once", sender: "did", accept: atLeast(5)},
+		{name: "payments not accepted stay unclaimed", sender: "did", accept: atLeast(1), txID: "small"},
+		{name: "payments of other senders", sender: "nobody", accept: atLeast(1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment, ok := payments.Claim(tt.sender, tt.accept)
+			if ok != (tt.txID != "") || payment.TxID != tt.txID {
+				t.Fatalf("Claim(%v) = %q (%v), want %q", tt.sender, payment.TxID, ok, tt.txID)
+			}
+		})
+	}
+}
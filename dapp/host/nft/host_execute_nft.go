@@ -5,12 +5,12 @@ import (
 	"fmt"
 
 	"github.com/bytecodealliance/wasmtime-go"
-	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
 
-	"github.com/gorilla/websocket"
 	wasmContext "github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
+
+	"dapp/wallet"
 )
 
 type ExecuteNFTReq struct {
@@ -28,11 +28,11 @@ type DoExecuteNFT struct {
 	memory      *wasmtime.Memory
 	nodeAddress string
 	quorumType  int
-	wasmContext *context.WasmContext
+	signer      wallet.Signer
 }
 
-func NewDoExecuteNFT() *DoExecuteNFT {
-	return &DoExecuteNFT{}
+func NewDoExecuteNFT(signer wallet.Signer) *DoExecuteNFT {
+	return &DoExecuteNFT{signer: signer}
 }
 func (h *DoExecuteNFT) Name() string {
 	return "do_execute_nft"
@@ -54,58 +54,37 @@ func (h *DoExecuteNFT) Initialize(allocFunc, deallocFunc *wasmtime.Func, memory
 	h.memory = memory
 	h.nodeAddress = nodeAddress
 	h.quorumType = quorumType
-	h.wasmContext = wasmCtx
 }
 
 func (h *DoExecuteNFT) Callback() host.HostFunctionCallBack {
 	return h.callback
 }
-func callExecuteNFTAPI(webSocketConn *websocket.Conn, nodeAddress string, quorumType int, executeNFTdata ExecuteNFTReq) error {
+func callExecuteNFTAPI(signer wallet.Signer, quorumType int, executeNFTdata ExecuteNFTReq) error {
 	executeNFTdata.QuorumType = int32(quorumType)
 	fmt.Println("printing the data in callExecuteNFTAPI function is:", executeNFTdata)
 
-	executeNFTdataBytes, _ := json.Marshal(executeNFTdata)
-	var executeNFTDataMap map[string]interface{} = make(map[string]interface{})
-
-	if err := json.Unmarshal(executeNFTdataBytes, &executeNFTDataMap); err != nil {
-		return fmt.Errorf("error unmarshalling transferFTdataBytes: %v", err)
-	}
-
-	msgPayload := map[string]interface{}{
-		"type": "OPEN_EXTENSION",
-		"data": &ExtensionCommand{
-			Action:  "EXECUTE_NFT",
-			Payload: executeNFTDataMap,
-		},
-	}
-
-	// errDeadline := webSocketConn.SetReadDeadline(time.Now().Add(5 * time.Minute))
-	// if errDeadline != nil {
-	// 	return fmt.Errorf("error setting read deadline for web socket connection, err: %v", errDeadline)
-	// }
-
-	msgPayloadBytes, _ := json.Marshal(msgPayload)
-
-	err := webSocketConn.WriteMessage(websocket.TextMessage, msgPayloadBytes)
+	resultBytes, err := signer.Send("EXECUTE_NFT", executeNFTdata)
 	if err != nil {
-		return fmt.Errorf("error occured while invoking NFT Execute thrice, err: %v", err)
+		return fmt.Errorf("error occured while invoking NFT Execute, err: %w", err)
 	}
 
-	_, _, err = webSocketConn.ReadMessage()
-	if err != nil {
-		return fmt.Errorf("unable to read response from web socket connection for Execute NFT, err: %v", err)
+	var basicResponse *BasicResponse
+	if err := json.Unmarshal(resultBytes, &basicResponse); err != nil {
+		return fmt.Errorf("unable to unmarshal the results for ExecuteNFT API call, err: %v", err)
+	}
+	if !basicResponse.Status {
+		return fmt.Errorf("error in response for NFT Execute: %s", basicResponse.Message)
 	}
 
-	return err
+	return nil
 }
 
 func (h *DoExecuteNFT) callback(
 	caller *wasmtime.Caller,
 	args []wasmtime.Val,
 ) ([]wasmtime.Val, *wasmtime.Trap) {
-	trieSocketConn := h.wasmContext.ExternalSocketConn()
-	if trieSocketConn == nil {
-		return utils.HandleError("websocket connection for DoExecuteNFT is not initialized")
+	if h.signer == nil {
+		return utils.HandleError("wallet signer for DoExecuteNFT is not initialized")
 	}
 
 	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
@@ -126,11 +105,10 @@ func (h *DoExecuteNFT) callback(
 		errMsg := "Error unmashalling response in callback function" + err3.Error()
 		return utils.HandleError(errMsg)
 	}
-	callExecuteNFTAPIRespErr := callExecuteNFTAPI(trieSocketConn, h.nodeAddress, h.quorumType, executeNFTData)
+	callExecuteNFTAPIRespErr := callExecuteNFTAPI(h.signer, h.quorumType, executeNFTData)
 	if callExecuteNFTAPIRespErr != nil {
 		fmt.Println("failed to execute NFT", callExecuteNFTAPIRespErr)
-		errMsg := "failed to execute NFT" + callExecuteNFTAPIRespErr.Error()
-		return utils.HandleError(errMsg)
+		return wallet.HandleCommandError(caller, h.allocFunc, outputArgs, fmt.Errorf("failed to execute NFT, err: %w", callExecuteNFTAPIRespErr))
 	}
 
 	responseStr := "success"
@@ -7,16 +7,19 @@ import (
 	// "io/ioutil"
 
 	"github.com/bytecodealliance/wasmtime-go"
-	"github.com/gorilla/websocket"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+
+	"dapp/events"
+	"dapp/wallet"
 )
 
 type BasicResponse struct {
 	Message string `json:"message"`
 	Result  string `json:"result"`
 	Status  bool   `json:"status"`
+	TxID    string `json:"tx_id,omitempty"`
 }
 
 type DoMintNFTApiCall struct {
@@ -24,7 +27,8 @@ type DoMintNFTApiCall struct {
 	memory      *wasmtime.Memory
 	nodeAddress string
 	quorumType  int
-	wasmContext *context.WasmContext
+	signer      wallet.Signer
+	publisher   events.Publisher
 }
 
 type MintNFTData struct {
@@ -46,8 +50,8 @@ type deployNFTReq struct {
 	NFTFileName string `json:"nft_file_name"`
 }
 
-func NewDoMintNFTApiCall() *DoMintNFTApiCall {
-	return &DoMintNFTApiCall{}
+func NewDoMintNFTApiCall(signer wallet.Signer, publisher events.Publisher) *DoMintNFTApiCall {
+	return &DoMintNFTApiCall{signer: signer, publisher: publisher}
 }
 
 func (h *DoMintNFTApiCall) Name() string {
@@ -71,14 +75,13 @@ func (h *DoMintNFTApiCall) Initialize(allocFunc, deallocFunc *wasmtime.Func, mem
 	h.memory = memory
 	h.nodeAddress = nodeAddress
 	h.quorumType = quorumType
-	h.wasmContext = wasmContext
 }
 
 func (h *DoMintNFTApiCall) Callback() host.HostFunctionCallBack {
 	return h.callback
 }
 
-func callDeployNFTAPI(webSocketConn *websocket.Conn, nodeAddress string, quorumType int, mintNFTData MintNFTData) (string, error) {
+func callDeployNFTAPI(signer wallet.Signer, quorumType int, mintNFTData MintNFTData) (string, error) {
 	var deployReq deployNFTReq
 
 	deployReq.Did = mintNFTData.Did
@@ -89,36 +92,9 @@ func callDeployNFTAPI(webSocketConn *websocket.Conn, nodeAddress string, quorumT
 	deployReq.NFTMetadata = mintNFTData.NftMetadata
 	deployReq.NFTFileName = mintNFTData.NFTFileName
 
-	deployNFTdataBytes, _ := json.Marshal(deployReq)
-	var mintNFTDataMap map[string]interface{} = make(map[string]interface{})
-
-	if err := json.Unmarshal(deployNFTdataBytes, &mintNFTDataMap); err != nil {
-		return "", fmt.Errorf("error unmarshalling mintNFTBytes: %v", err)
-	}
-
-	msgPayload := map[string]interface{}{
-		"type": "OPEN_EXTENSION",
-		"data": &ExtensionCommand{
-			Action:  "DEPLOY_NFT",
-			Payload: mintNFTDataMap,
-		},
-	}
-
-	msgPayloadBytes, _ := json.Marshal(msgPayload)
-
-	// errDeadline := webSocketConn.SetReadDeadline(time.Now().Add(5 * time.Minute))
-	// if errDeadline != nil {
-	// 	return "", fmt.Errorf("error setting read deadline for web socket connection, err: %v", errDeadline)
-	// }
-
-	err := webSocketConn.WriteMessage(websocket.TextMessage, msgPayloadBytes)
+	resultBytes, err := signer.Send("DEPLOY_NFT", deployReq)
 	if err != nil {
-		return "", fmt.Errorf("error occured while invoking Deploy NFT thrice, err: %v", err)
-	}
-
-	_, resultBytes, err := webSocketConn.ReadMessage()
-	if err != nil {
-		return "", fmt.Errorf("unable to read response from web socket connection for Deploy NFT, err: %v", err)
+		return "", fmt.Errorf("error occured while invoking Deploy NFT, err: %w", err)
 	}
 
 	fmt.Println("Payload via websocket:", string(resultBytes))
@@ -127,7 +103,13 @@ func callDeployNFTAPI(webSocketConn *websocket.Conn, nodeAddress string, quorumT
 		return "", fmt.Errorf("unable to unmarshal the results for DeployNFT API call, err: %v", err)
 	}
 
-	txID, err := extractTransactionIDFromMessage(basicResponse.Message)
+	// Wallets which do not report the transaction ID on its own leave it
+	// at the end of the message, as the Rubix node does
+	if basicResponse.TxID != "" {
+		return basicResponse.TxID, nil
+	}
+
+	txID, err := wallet.TransactionIDFromMessage(basicResponse.Message)
 	if err != nil {
 		return "", err
 	}
@@ -139,7 +121,9 @@ func (h *DoMintNFTApiCall) callback(
 	caller *wasmtime.Caller,
 	args []wasmtime.Val,
 ) ([]wasmtime.Val, *wasmtime.Trap) {
-	trieServerSocketConnUrl := h.wasmContext.ExternalSocketConn()
+	if h.signer == nil {
+		return utils.HandleError("wallet signer for DoMintNFTApiCall is not initialized")
+	}
 
 	// Validate the number of arguments
 	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
@@ -161,10 +145,9 @@ func (h *DoMintNFTApiCall) callback(
 		return utils.HandleError(errMsg)
 	}
 
-	nftDeployTxID, errDeploy := callDeployNFTAPI(trieServerSocketConnUrl, h.nodeAddress, h.quorumType, mintNFTData)
+	nftDeployTxID, errDeploy := callDeployNFTAPI(h.signer, h.quorumType, mintNFTData)
 	if errDeploy != nil {
-		errMsg := "Deploy NFT API failed" + errDeploy.Error()
-		return utils.HandleError(errMsg)
+		return wallet.HandleCommandError(caller, h.allocFunc, outputArgs, fmt.Errorf("Deploy NFT API failed, err: %w", errDeploy))
 	}
 
 	responseStr := func() string {
@@ -186,5 +169,13 @@ func (h *DoMintNFTApiCall) callback(
 		return utils.HandleError(err.Error())
 	}
 
+	h.publisher.Publish(events.TopicAssetPublished, map[string]interface{}{
+		"nft_id":    mintNFTData.NftId,
+		"tx_id":     nftDeployTxID,
+		"owner_did": mintNFTData.Did,
+		"file_name": mintNFTData.NFTFileName,
+		"value":     mintNFTData.NftValue,
+	}, mintNFTData.Did)
+
 	return utils.HandleOk() // Success
 }
@@ -0,0 +1,123 @@
+package nft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+
+	wasmContext "github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
+)
+
+type ReadAssetMetadataReq struct {
+	NFT string `json:"nft"`
+}
+
+type AssetMetadata struct {
+	NFT      string                 `json:"nft"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// DoReadAssetMetadata returns the metadata of an asset, so that contracts
+// can enforce the price, type or license terms it states. The metadata.json
+// file under RUBIX_NFT_DIR is read first, and the metadata reported by the
+// node otherwise. It needs no wallet approval.
+type DoReadAssetMetadata struct {
+	allocFunc   *wasmtime.Func
+	memory      *wasmtime.Memory
+	nodeAddress string
+}
+
+func NewDoReadAssetMetadata() *DoReadAssetMetadata {
+	return &DoReadAssetMetadata{}
+}
+
+func (h *DoReadAssetMetadata) Name() string {
+	return "do_read_asset_metadata"
+}
+
+func (h *DoReadAssetMetadata) FuncType() *wasmtime.FuncType {
+	return wasmtime.NewFuncType(
+		[]*wasmtime.ValType{
+			wasmtime.NewValType(wasmtime.KindI32), // input_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // input_len
+			wasmtime.NewValType(wasmtime.KindI32), // resp_ptr_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // resp_len_ptr
+		},
+		[]*wasmtime.ValType{wasmtime.NewValType(wasmtime.KindI32)}, // return i32
+	)
+}
+
+func (h *DoReadAssetMetadata) Initialize(allocFunc, deallocFunc *wasmtime.Func, memory *wasmtime.Memory, nodeAddress string, quorumType int, wasmCtx *wasmContext.WasmContext) {
+	h.allocFunc = allocFunc
+	h.memory = memory
+	h.nodeAddress = nodeAddress
+}
+
+func (h *DoReadAssetMetadata) Callback() host.HostFunctionCallBack {
+	return h.callback
+}
+
+// readAssetMetadata falls back on the node only when there is no metadata
+// file, so that a malformed file is not hidden by the node's copy
+func readAssetMetadata(nodeAddress string, nftID string) (map[string]interface{}, error) {
+	metadata, err := ReadLocalMetadata(nftID)
+	if err == nil {
+		return metadata, nil
+	}
+	if !errors.Is(err, ErrNoLocalMetadata) {
+		return nil, err
+	}
+
+	metadata, nodeErr := callListNFTsAPI(nodeAddress, nftID)
+	if nodeErr != nil {
+		return nil, fmt.Errorf("%v, and the node has none: %v", err, nodeErr)
+	}
+
+	return metadata, nil
+}
+
+func (h *DoReadAssetMetadata) callback(
+	caller *wasmtime.Caller,
+	args []wasmtime.Val,
+) ([]wasmtime.Val, *wasmtime.Trap) {
+	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
+
+	inputBytes, memory, err := utils.ExtractDataFromWASM(caller, inputArgs)
+	if err != nil {
+		fmt.Println("Failed to extract data from WASM", err)
+		return utils.HandleError(err.Error())
+	}
+	h.memory = memory // Assign memory to Host struct for future use
+
+	var readMetadataData ReadAssetMetadataReq
+	if err := json.Unmarshal(inputBytes, &readMetadataData); err != nil {
+		fmt.Println("Error unmarshaling response in callback function:", err)
+		return utils.HandleError("Error unmarshaling response in callback function:" + err.Error())
+	}
+	if readMetadataData.NFT == "" {
+		return utils.HandleError("nft is required to read the asset metadata")
+	}
+
+	metadata, err := readAssetMetadata(h.nodeAddress, readMetadataData.NFT)
+	if err != nil {
+		fmt.Println("failed to read asset metadata", err)
+		return utils.HandleError(fmt.Sprintf("failed to read the metadata of NFT %v, err: %v", readMetadataData.NFT, err))
+	}
+
+	responseBytes, _ := json.Marshal(&AssetMetadata{
+		NFT:      readMetadataData.NFT,
+		Metadata: metadata,
+	})
+
+	err = utils.UpdateDataToWASM(caller, h.allocFunc, string(responseBytes), outputArgs)
+	if err != nil {
+		fmt.Println("Failed to update data to WASM", err)
+		return utils.HandleError(err.Error())
+	}
+
+	return utils.HandleOk()
+}
@@ -0,0 +1,147 @@
+package nft
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+
+	wasmContext "github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
+
+	"dapp/events"
+	"dapp/wallet"
+)
+
+type TransferNFTReq struct {
+	NFT        string  `json:"nft"`
+	Owner      string  `json:"owner"`
+	Receiver   string  `json:"receiver"`
+	Comment    string  `json:"comment"`
+	NFTValue   float64 `json:"nft_value"`
+	NFTData    string  `json:"nft_data"`
+	QuorumType int32   `json:"quorum_type"`
+}
+
+// DoTransferNFT hands an NFT over to a new owner, once the current owner
+// approves the transfer in their wallet. It replaces the do_transfer_nft host
+// function predefined by the bridge, which calls the node without any
+// approval.
+type DoTransferNFT struct {
+	allocFunc   *wasmtime.Func
+	memory      *wasmtime.Memory
+	nodeAddress string
+	quorumType  int
+	signer      wallet.Signer
+	publisher   events.Publisher
+}
+
+func NewDoTransferNFT(signer wallet.Signer, publisher events.Publisher) *DoTransferNFT {
+	return &DoTransferNFT{signer: signer, publisher: publisher}
+}
+func (h *DoTransferNFT) Name() string {
+	return "do_transfer_nft"
+}
+func (h *DoTransferNFT) FuncType() *wasmtime.FuncType {
+	return wasmtime.NewFuncType(
+		[]*wasmtime.ValType{
+			wasmtime.NewValType(wasmtime.KindI32), // input_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // input_len
+			wasmtime.NewValType(wasmtime.KindI32), // resp_ptr_ptr
+			wasmtime.NewValType(wasmtime.KindI32), // resp_len_ptr
+		},
+		[]*wasmtime.ValType{wasmtime.NewValType(wasmtime.KindI32)}, // return i32
+	)
+}
+
+func (h *DoTransferNFT) Initialize(allocFunc, deallocFunc *wasmtime.Func, memory *wasmtime.Memory, nodeAddress string, quorumType int, wasmCtx *wasmContext.WasmContext) {
+	h.allocFunc = allocFunc
+	h.memory = memory
+	h.nodeAddress = nodeAddress
+	h.quorumType = quorumType
+}
+
+func (h *DoTransferNFT) Callback() host.HostFunctionCallBack {
+	return h.callback
+}
+
+func callTransferNFTAPI(signer wallet.Signer, quorumType int, transferNFTData TransferNFTReq) (string, error) {
+	transferNFTData.QuorumType = int32(quorumType)
+
+	resultBytes, err := signer.Send("TRANSFER_NFT", transferNFTData)
+	if err != nil {
+		return "", fmt.Errorf("error occured while invoking NFT Transfer, err: %w", err)
+	}
+
+	var basicResponse *BasicResponse
+	if err := json.Unmarshal(resultBytes, &basicResponse); err != nil {
+		return "", fmt.Errorf("unable to unmarshal the results for TransferNFT API call, err: %v", err)
+	}
+	if !basicResponse.Status {
+		return "", fmt.Errorf("error in response for NFT Transfer: %s", basicResponse.Message)
+	}
+
+	if basicResponse.TxID != "" {
+		return basicResponse.TxID, nil
+	}
+
+	return wallet.TransactionIDFromMessage(basicResponse.Message)
+}
+
+func (h *DoTransferNFT) callback(
+	caller *wasmtime.Caller,
+	args []wasmtime.Val,
+) ([]wasmtime.Val, *wasmtime.Trap) {
+	if h.signer == nil {
+		return utils.HandleError("wallet signer for DoTransferNFT is not initialized")
+	}
+
+	inputArgs, outputArgs := utils.HostFunctionParamExtraction(args, true, true)
+
+	inputBytes, memory, err := utils.ExtractDataFromWASM(caller, inputArgs)
+	if err != nil {
+		fmt.Println("Failed to extract data from WASM", err)
+		return utils.HandleError(err.Error())
+	}
+	h.memory = memory // Assign memory to Host struct for future use
+
+	var transferNFTData TransferNFTReq
+	if err := json.Unmarshal(inputBytes, &transferNFTData); err != nil {
+		fmt.Println("Error unmarshaling response in callback function:", err)
+		return utils.HandleError("Error unmarshaling response in callback function:" + err.Error())
+	}
+	if transferNFTData.Owner == transferNFTData.Receiver {
+		return utils.HandleError(fmt.Sprintf("NFT %v cannot be transferred to its own owner", transferNFTData.NFT))
+	}
+
+	txID, err := callTransferNFTAPI(h.signer, h.quorumType, transferNFTData)
+	if err != nil {
+		fmt.Println("failed to transfer NFT", err)
+		return wallet.HandleCommandError(caller, h.allocFunc, outputArgs, fmt.Errorf("failed to transfer NFT, err: %w", err))
+	}
+
+	responseBytes, _ := json.Marshal(struct {
+		NftId string `json:"nftId"`
+		TxId  string `json:"txId"`
+	}{
+		NftId: transferNFTData.NFT,
+		TxId:  txID,
+	})
+
+	err = utils.UpdateDataToWASM(caller, h.allocFunc, string(responseBytes), outputArgs)
+	if err != nil {
+		fmt.Println("Failed to update data to WASM", err)
+		return utils.HandleError(err.Error())
+	}
+
+	h.publisher.Publish(events.TopicAssetTransferred, map[string]interface{}{
+		"nft_id":       transferNFTData.NFT,
+		"tx_id":        txID,
+		"owner_did":    transferNFTData.Owner,
+		"receiver_did": transferNFTData.Receiver,
+		"value":        transferNFTData.NFTValue,
+	}, transferNFTData.Owner, transferNFTData.Receiver)
+
+	return utils.HandleOk() // Success
+}
@@ -0,0 +1,110 @@
+package nft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+// NFT_LIST_TIMEOUT bounds the request of the NFT list to the Rubix node
+const NFT_LIST_TIMEOUT = 30 * time.Second
+
+// ErrNoLocalMetadata is returned by ReadLocalMetadata when RUBIX_NFT_DIR holds
+// no metadata for an NFT
+var ErrNoLocalMetadata = errors.New("no local metadata")
+
+// listNFTsResponse is the response of the /api/list-nfts node API
+type listNFTsResponse struct {
+	BasicResponse
+	Nfts []struct {
+		Nft         string `json:"nft"`
+		NftMetadata string `json:"nft_metadata"`
+	} `json:"nfts"`
+}
+
+// ReadLocalMetadata reads the metadata.json file of an NFT from the NFT
+// directory of the Rubix node, given by RUBIX_NFT_DIR
+func ReadLocalMetadata(nftID string) (map[string]interface{}, error) {
+	if nftID == "" || nftID == "." || nftID == ".." || nftID != path.Base(nftID) {
+		return nil, fmt.Errorf("invalid NFT ID %q", nftID)
+	}
+
+	rubixNftDir := os.Getenv("RUBIX_NFT_DIR")
+	if rubixNftDir == "" {
+		return nil, fmt.Errorf("%w: RUBIX_NFT_DIR environment variable not set", ErrNoLocalMetadata)
+	}
+
+	assetMetadataDir := path.Join(rubixNftDir, nftID, "metadata.json")
+
+	assetMetadataObj, err := os.ReadFile(assetMetadataDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: failed to read asset metadata file: %v", ErrNoLocalMetadata, err)
+		}
+		return nil, fmt.Errorf("failed to read asset metadata file: %v", err)
+	}
+
+	if len(assetMetadataObj) == 0 {
+		return nil, fmt.Errorf("%w: metadata file for NFT ID %v is empty", ErrNoLocalMetadata, nftID)
+	}
+
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(assetMetadataObj, &metadata); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal metadata JSON: %v", err)
+	}
+
+	return metadata, nil
+}
+
+// callListNFTsAPI returns the metadata of an NFT from the nft_metadata field of
+// the /api/list-nfts node API
+func callListNFTsAPI(nodeAddress string, nftID string) (map[string]interface{}, error) {
+	targetURL, err := url.JoinPath(nodeAddress, "/api/list-nfts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct URL: %w", err)
+	}
+
+	client := &http.Client{Timeout: NFT_LIST_TIMEOUT}
+	resp, err := client.Get(targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("GET request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var response listNFTsResponse
+	if err := json.Unmarshal(respBytes, &response); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal the NFT list, err: %v", err)
+	}
+
+	for _, nft := range response.Nfts {
+		if nft.Nft != nftID {
+			continue
+		}
+		if nft.NftMetadata == "" {
+			return nil, fmt.Errorf("NFT %v has no metadata", nftID)
+		}
+
+		var metadata map[string]interface{}
+		if err := json.Unmarshal([]byte(nft.NftMetadata), &metadata); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal the metadata of NFT %v: %v", nftID, err)
+		}
+		return metadata, nil
+	}
+
+	return nil, fmt.Errorf("NFT %v is not known to the node", nftID)
+}
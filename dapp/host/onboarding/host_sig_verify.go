@@ -22,6 +22,7 @@ import (
 	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
 	rubixCrypto "github.com/rubixchain/rubixgoplatform/crypto"
 
+	"dapp/events"
 	"dapp/host/onboarding/store"
 
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
@@ -31,10 +32,15 @@ type VerifyAction struct {
 	allocFunc   *wasmtime.Func
 	memory      *wasmtime.Memory
 	nodeAddress string
+	publisher   events.Publisher
+
+	// dryRun verifies the onboarding without storing the provider, for the
+	// dry runs of the contract
+	dryRun bool
 }
 
-func NewVerifyAction() *VerifyAction {
-	return &VerifyAction{}
+func NewVerifyAction(publisher events.Publisher, dryRun bool) *VerifyAction {
+	return &VerifyAction{publisher: publisher, dryRun: dryRun}
 }
 
 func (h *VerifyAction) Name() string {
@@ -67,7 +73,7 @@ func (h *VerifyAction) callback(
 ) ([]wasmtime.Val, *wasmtime.Trap) {
 	_, outputArgs := utils.HostFunctionParamExtraction(args, false, true)
 
-	smartContractInfo, err := getSmartContractInfo(h.nodeAddress, ONBOARDING_CONTRACT_ADDRESS)
+	smartContractInfo, err := GetSmartContractInfo(h.nodeAddress, ONBOARDING_CONTRACT_ADDRESS)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to get smart contract info, err: %v", err)
 		fmt.Println(errMsg)
@@ -88,7 +94,7 @@ func (h *VerifyAction) callback(
 
 	completeDidPath := path.Join(DID_DIR, executorDID, "pubKey.pem")
 
-	executorPubKey, err := getPubKeyFromFile(completeDidPath, executorDID)
+	executorPubKey, err := GetPubKeyFromFile(completeDidPath, executorDID)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to load pub key, err: %v", err)
 		fmt.Println(errMsg)
@@ -122,7 +128,7 @@ func (h *VerifyAction) callback(
 		return utils.HandleError(errMsg)
 	}
 
-	isSignatureValid, err := verifyPlatformSignature(executorMsg, executorPubKey, executorSignature)
+	isSignatureValid, err := VerifyPlatformSignature(executorMsg, executorPubKey, executorSignature)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to verify signature, err : %v", err)
 		fmt.Println(errMsg)
@@ -130,13 +136,17 @@ func (h *VerifyAction) callback(
 	}
 
 	if isSignatureValid {
-		err := store.StoreDepinProviderInfo(providerInfoObj)
-		if err != nil {
-			errMsg := fmt.Sprintf("unable to store Provider Info, err: %v", err)
-			fmt.Println(errMsg)
-			return utils.HandleError(errMsg)
+		if !h.dryRun {
+			err := store.StoreDepinProviderInfo(providerInfoObj)
+			if err != nil {
+				errMsg := fmt.Sprintf("unable to store Provider Info, err: %v", err)
+				fmt.Println(errMsg)
+				return utils.HandleError(errMsg)
+			}
 		}
 
+		h.publisher.Publish(events.TopicProviderOnboarded, providerInfoObj, providerInfoObj.ProviderDid)
+
 		responseStr := "Success"
 		err = utils.UpdateDataToWASM(caller, h.allocFunc, responseStr, outputArgs)
 		if err != nil {
@@ -157,7 +167,8 @@ func (h *VerifyAction) callback(
 	}
 }
 
-func getPubKeyFromFile(path string, did string) (*ecdsa.PublicKey, error) {
+// GetPubKeyFromFile loads the public key of did from its pubKey.pem file
+func GetPubKeyFromFile(path string, did string) (*ecdsa.PublicKey, error) {
 	fileObj, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -178,7 +189,8 @@ func getPubKeyFromFile(path string, did string) (*ecdsa.PublicKey, error) {
 	return pubKeySer, nil
 }
 
-func verifyPlatformSignature(message string, pubKey *ecdsa.PublicKey, signature string) (bool, error) {
+// VerifyPlatformSignature checks the hex encoded signature of message
+func VerifyPlatformSignature(message string, pubKey *ecdsa.PublicKey, signature string) (bool, error) {
 	messageBytes := []byte(message)
 
 	signatureBytes, err := hex.DecodeString(signature)
@@ -189,10 +201,22 @@ func verifyPlatformSignature(message string, pubKey *ecdsa.PublicKey, signature
 	return ecdsa.VerifyASN1(pubKey, messageBytes, signatureBytes), nil
 }
 
-func getSmartContractInfo(addr string, smartContractHash string) ([]SCTDataReply, error) {
+// GetSmartContractInfo fetches the latest block of the smart contract
+// smartContractHash from the Rubix node at addr
+func GetSmartContractInfo(addr string, smartContractHash string) ([]SCTDataReply, error) {
+	return getSmartContractTokenChainData(addr, smartContractHash, true)
+}
+
+// GetSmartContractChain fetches every block of the smart contract
+// smartContractHash from the Rubix node at addr
+func GetSmartContractChain(addr string, smartContractHash string) ([]SCTDataReply, error) {
+	return getSmartContractTokenChainData(addr, smartContractHash, false)
+}
+
+func getSmartContractTokenChainData(addr string, smartContractHash string, latest bool) ([]SCTDataReply, error) {
 	reqData := map[string]interface{}{
 		"token":  smartContractHash,
-		"latest": true,
+		"latest": latest,
 	}
 	fmt.Println("Get the contract hash: ", smartContractHash)
 	bodyJSON, err := json.Marshal(reqData)
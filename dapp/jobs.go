@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"dapp/wallet"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const DEFAULT_CONTRACT_WORKERS = 4
+const JOB_QUEUE_CAPACITY = 100
+const JOB_RETENTION = 24 * time.Hour
+
+// JOB_CANCEL_WAIT is how long a shutdown waits for the executions cancelled
+// at its deadline to wind down. A contract busy computing only stops at its
+// own deadline, as cancellation is noticed on its next host call.
+const JOB_CANCEL_WAIT = 5 * time.Second
+
+type JobState string
+
+const (
+	JobQueued         JobState = "queued"
+	JobRunning        JobState = "running"
+	JobAwaitingWallet JobState = "awaiting_wallet"
+	JobSucceeded      JobState = "succeeded"
+	JobFailed         JobState = "failed"
+	JobTimedOut       JobState = "timed_out"
+	JobOutOfFuel      JobState = "out_of_fuel"
+	JobOutOfMemory    JobState = "out_of_memory"
+)
+
+func (state JobState) finished() bool {
+	switch state {
+	case JobSucceeded, JobFailed, JobTimedOut, JobOutOfFuel, JobOutOfMemory:
+		return true
+	default:
+		return false
+	}
+}
+
+var ErrJobQueueFull = errors.New("contract execution queue is full, please retry later")
+var ErrShuttingDown = errors.New("dapp is shutting down, please retry later")
+
+// Job is a contract execution accepted by the dapp and run asynchronously
+// by the worker pool. WalletOutcome tells why a job failed on the wallet,
+// for instance because the user rejected a command.
+type Job struct {
+	ID            string         `json:"id"`
+	Contract      string         `json:"contract"`
+	InitiatorDID  string         `json:"initiator_did"`
+	State         JobState       `json:"state"`
+	ExecutionID   string         `json:"execution_id,omitempty"`
+	WalletOutcome wallet.Outcome `json:"wallet_outcome,omitempty"`
+	Result        string         `json:"result,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+
+	request     *ContractInputRequest
+	callbackKey string
+}
+
+// JobQueue runs contract executions on a fixed pool of workers and keeps
+// the state of every job submitted to it
+type JobQueue struct {
+	server *Server
+	queue  chan *Job
+
+	// ctx is cancelled when a shutdown gives up on draining the queue
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	mu      sync.RWMutex
+	jobs    map[string]*Job
+	closing bool
+}
+
+func contractWorkerCount() int {
+	workers, err := strconv.Atoi(os.Getenv("CONTRACT_WORKERS"))
+	if err != nil || workers <= 0 {
+		return DEFAULT_CONTRACT_WORKERS
+	}
+	return workers
+}
+
+func NewJobQueue(server *Server, workers int) *JobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &JobQueue{
+		server: server,
+		queue:  make(chan *Job, JOB_QUEUE_CAPACITY),
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(map[string]*Job),
+	}
+
+	q.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+
+	// A wallet connecting again, or from another tab, is told about the
+	// jobs still in progress for its DID
+	TrieClients.Subscribe(func(event wallet.Event) {
+		if event.Type == wallet.EventConnected || event.Type == wallet.EventReconnected {
+			go q.resume(event.Session)
+		}
+	})
+
+	return q
+}
+
+func newJobID() string {
+	id := make([]byte, 12)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Submit queues the execution of the registered contract name. The outcome of
+// the job is recorded against callbackKey in the callback index.
+func (q *JobQueue) Submit(name string, contractInputRequest *ContractInputRequest, callbackKey string) (*Job, error) {
+	if _, ok := contractRegistry[name]; !ok {
+		return nil, fmt.Errorf("contract %s is not registered", name)
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:           newJobID(),
+		Contract:     name,
+		InitiatorDID: contractInputRequest.InitiatorDID,
+		State:        JobQueued,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		request:      contractInputRequest,
+		callbackKey:  callbackKey,
+	}
+
+	// The queue is only sent to under the lock, so that it is not closed
+	// by a shutdown in the meantime
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closing {
+		return nil, ErrShuttingDown
+	}
+	q.pruneLocked()
+
+	// The queued state is returned to the submitter, later states are pushed.
+	// It is copied before the job is handed to the workers.
+	queued := *job
+
+	select {
+	case q.queue <- job:
+	default:
+		return nil, ErrJobQueueFull
+	}
+	q.jobs[job.ID] = job
+
+	return &queued, nil
+}
+
+// Close stops accepting jobs. The jobs already queued still run.
+func (q *JobQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closing {
+		q.closing = true
+		close(q.queue)
+	}
+}
+
+// Drain closes the queue, and waits until ctx is done for the queued and
+// running jobs to finish. The executions still running then are cancelled,
+// and the jobs not started yet fail without running.
+func (q *JobQueue) Drain(ctx context.Context) error {
+	q.Close()
+
+	drained := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.cancel()
+
+	select {
+	case <-drained:
+		return fmt.Errorf("contract executions cancelled, err: %w", ctx.Err())
+	case <-time.After(JOB_CANCEL_WAIT):
+		return fmt.Errorf("contract executions still running after being cancelled, err: %w", ctx.Err())
+	}
+}
+
+// Get returns a snapshot of the job with the given ID
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// pruneLocked drops the finished jobs older than JOB_RETENTION. Their
+// outcome remains available in the execution journal.
+func (q *JobQueue) pruneLocked() {
+	for id, job := range q.jobs {
+		if job.State.finished() && time.Since(job.UpdatedAt) > JOB_RETENTION {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *JobQueue) update(job *Job, fn func(job *Job)) {
+	q.mu.Lock()
+	fn(job)
+	job.UpdatedAt = time.Now()
+	q.mu.Unlock()
+
+	q.notify(job)
+}
+
+func (q *JobQueue) worker() {
+	defer q.workers.Done()
+
+	for job := range q.queue {
+		q.run(job)
+	}
+}
+
+func (q *JobQueue) run(job *Job) {
+	if q.ctx.Err() != nil {
+		q.finish(job, nil, ErrShuttingDown)
+		return
+	}
+
+	q.update(job, func(job *Job) {
+		job.State = JobRunning
+	})
+
+	record, err := q.server.executeContract(q.ctx, job.Contract, job.request, q.trackExecution(job))
+	q.finish(job, record, err)
+}
+
+// finish records the outcome of the job, and of its execution if it ran
+func (q *JobQueue) finish(job *Job, record *ExecutionRecord, err error) {
+
+	q.update(job, func(job *Job) {
+		if record != nil {
+			job.ExecutionID = record.ID
+			job.WalletOutcome = record.WalletOutcome
+		}
+		// The job ends in the same state as its execution, so that
+		// timeouts and fuel exhaustion are told apart from other failures
+		job.State = JobState(executionStatusOf(err))
+		if err != nil {
+			fmt.Printf("job %s for contract %s ended as %s, err: %v\n", job.ID, job.Contract, job.State, err)
+			job.Error = err.Error()
+		} else {
+			job.Result = record.Result
+		}
+	})
+
+	// A job dropped by the shutdown never ran, so its callback is
+	// forgotten for a retry after the restart to execute it
+	if errors.Is(err, ErrShuttingDown) {
+		if err := q.server.Callbacks.Forget(job.callbackKey); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	q.mu.RLock()
+	finished := *job
+	q.mu.RUnlock()
+
+	if err := q.server.Callbacks.Put(job.callbackKey, finished); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// trackExecution links the job to its execution, and moves the job to the
+// awaiting_wallet state whenever the contract waits on the user's wallet
+func (q *JobQueue) trackExecution(job *Job) ExecutionOption {
+	return func(x *Execution) {
+		q.update(job, func(job *Job) {
+			job.ExecutionID = x.Record.ID
+		})
+
+		x.onWalletWait = func(waiting bool) {
+			q.update(job, func(job *Job) {
+				if waiting {
+					job.State = JobAwaitingWallet
+				} else {
+					job.State = JobRunning
+				}
+			})
+		}
+	}
+}
+
+// notify pushes the job state to every wallet session of the initiator
+func (q *JobQueue) notify(job *Job) {
+	q.mu.RLock()
+	snapshot := *job
+	q.mu.RUnlock()
+
+	for _, session := range TrieClients.Sessions(snapshot.InitiatorDID) {
+		if err := session.Broker.Push("JOB_STATUS", snapshot); err != nil {
+			fmt.Printf("unable to push status of job %s to %s (session %s), err: %v\n", snapshot.ID, snapshot.InitiatorDID, session.ID, err)
+		}
+	}
+}
+
+// resume pushes the state of the unfinished jobs of the session's DID to
+// the session
+func (q *JobQueue) resume(session wallet.Session) {
+	var snapshots []Job
+
+	q.mu.RLock()
+	for _, job := range q.jobs {
+		if job.InitiatorDID == session.DID && !job.State.finished() {
+			snapshots = append(snapshots, *job)
+		}
+	}
+	q.mu.RUnlock()
+
+	for _, snapshot := range snapshots {
+		if err := session.Broker.Push("JOB_STATUS", snapshot); err != nil {
+			fmt.Printf("unable to push status of job %s to %s (session %s), err: %v\n", snapshot.ID, session.DID, session.ID, err)
+			return
+		}
+	}
+}
+
+func (s *Server) handleGetJob(c *gin.Context) {
+	w := http.ResponseWriter(c.Writer)
+	enableCors(&w)
+
+	id := c.Param("id")
+
+	job, ok := s.Jobs.Get(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": false, "error": fmt.Sprintf("job %s not found", id)})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"dapp/wasmcache"
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/host"
+)
+
+// spinningContract computes until its deadline
+const spinningContract = `
+(module
+  (memory (export "memory") 1)
+  (func (export "alloc") (param i32) (result i32) (i32.const 1024))
+  (func (export "dealloc") (param i32 i32))
+  (func (export "spin_") (param i32 i32 i32 i32) (result i32)
+    (loop $spin (br $spin))
+    (i32.const 0)))
+`
+
+// newTestJobQueue serves the contract test_spin, which runs until its
+// timeout, from a queue with a single worker
+func newTestJobQueue(t *testing.T, timeout time.Duration) (*Server, *JobQueue) {
+	t.Helper()
+
+	dir := t.TempDir()
+	wasmBytes, err := wasmtime.Wat2Wasm(spinningContract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "spin.wasm"), wasmBytes, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modules, err := wasmcache.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contractRegistry["test_spin"] = &ContractSpec{
+		WasmFile: "spin.wasm",
+		HostFunctions: func(x *Execution) []host.HostFunction {
+			return nil
+		},
+		Timeout: timeout,
+	}
+	t.Cleanup(func() { delete(contractRegistry, "test_spin") })
+
+	// The deployment is already known to be registered and built from the
+	// artifact, so that its verification makes no network call
+	digest, err := modules.Digest("spin.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployments := &ContractDeployments{
+		deployments: map[string]*ContractDeployment{
+			"contract": {SmartContractHash: "contract", Contract: "test_spin", registered: true, deployedDigest: digest},
+		},
+	}
+
+	db := newTestDB(t)
+	server := &Server{
+		Modules:     modules,
+		Deployments: deployments,
+		Callbacks:   NewCallbackIndex(db),
+		Journal:     NewExecutionJournal(db),
+	}
+	server.Jobs = NewJobQueue(server, 1)
+	return server, server.Jobs
+}
+
+func spinRequest() *ContractInputRequest {
+	return &ContractInputRequest{SmartContractHash: "contract", SmartContractData: `{"spin": {}}`, InitiatorDID: "did"}
+}
+
+func TestJobQueueShutdown(t *testing.T) {
+	server, q := newTestJobQueue(t, 500*time.Millisecond)
+
+	submitted := make(map[string]*Job)
+	for _, key := range []string{"running", "queued"} {
+		job, _, err := server.Callbacks.Process(key, func() (*Job, error) {
+			return q.Submit("test_spin", spinRequest(), key)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		submitted[key] = job
+	}
+
+	// The first job starts running, the second waits for the only worker
+	time.Sleep(100 * time.Millisecond)
+
+	// The queued job is dropped once the drain deadline passes, while the
+	// running one computes until its own timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := q.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain() error = %v, want the executions to be cancelled", err)
+	}
+
+	if _, err := q.Submit("test_spin", spinRequest(), "late"); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("Submit() after Drain() error = %v, want %v", err, ErrShuttingDown)
+	}
+
+	tests := []struct {
+		key   string
+		state JobState
+		// duplicate tells whether a retry of the callback is answered with
+		// the outcome of the job rather than executed
+		duplicate bool
+	}{
+		{key: "running", state: JobTimedOut, duplicate: true},
+		{key: "queued", state: JobFailed, duplicate: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			job, ok := q.Get(submitted[tt.key].ID)
+			if !ok || job.State != tt.state {
+				t.Fatalf("job state = %v, want %v, err: %v", job.State, tt.state, job.Error)
+			}
+
+			_, duplicate, _ := server.Callbacks.Process(tt.key, func() (*Job, error) {
+				return nil, ErrShuttingDown
+			})
+			if duplicate != tt.duplicate {
+				t.Fatalf("retry of the callback duplicate = %v, want %v", duplicate, tt.duplicate)
+			}
+		})
+	}
+
+	if job, _ := q.Get(submitted["queued"].ID); job.Error != ErrShuttingDown.Error() {
+		t.Fatalf("queued job error = %q, want %q", job.Error, ErrShuttingDown)
+	}
+}
+
+func TestJobQueueDrainsInTime(t *testing.T) {
+	_, q := newTestJobQueue(t, 200*time.Millisecond)
+
+	job, err := q.Submit("test_spin", spinRequest(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Drain(ctx); err != nil {
+		t.Fatalf("Drain() error = %v, want the queue to drain", err)
+	}
+
+	if job, _ := q.Get(job.ID); !job.State.finished() {
+		t.Fatalf("job state = %v after Drain(), want a finished job", job.State)
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
-	"dapp/host/ft"
-	"dapp/host/nft"
-	"dapp/host/onboarding"
+	"context"
+	"dapp/events"
+	"dapp/host/credits"
+	"dapp/wallet"
+	"dapp/wasmcache"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,7 +15,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cache"
@@ -21,12 +25,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/syndtr/goleveldb/leveldb"
-
-	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
-	wasmContext "github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
 )
 
-var TrieClientsMap = make(map[string]*websocket.Conn)
+// TrieClients holds the sessions of every connected wallet, keyed by DID
+var TrieClients = wallet.NewRegistry()
+
+// EventBus carries the events pushed to the wallets and frontends subscribed
+// to them
+var EventBus = events.NewBus()
 
 var Upgrader = websocket.Upgrader{
 	// CheckOrigin allows connections from any origin, which is suitable for development
@@ -44,7 +50,19 @@ func enableCors(w *http.ResponseWriter) {
 }
 
 type Server struct {
-	DB *leveldb.DB
+	Credits     *credits.Ledger
+	Modules     *wasmcache.Cache
+	Deployments *ContractDeployments
+	Callbacks   *CallbackIndex
+	Journal     *ExecutionJournal
+	Jobs        *JobQueue
+
+	// NodeSigner signs the commands of the DID managed by the Rubix node
+	// when no wallet of it is connected. It is nil unless configured.
+	NodeSigner *wallet.NodeSigner
+
+	// CreditPrice is what the add_credits contract sells credits for
+	CreditPrice credits.Price
 }
 
 func main() {
@@ -54,9 +72,43 @@ func main() {
 	}
 	defer db.Close()
 
-	server := &Server{
-		DB: db,
+	executionDB, err := leveldb.OpenFile(EXECUTION_STORAGE, nil)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open execution journal: %v", err))
 	}
+	defer executionDB.Close()
+
+	modules, err := wasmcache.New(ARTIFACTS_DIR)
+	if err != nil {
+		panic(fmt.Sprintf("failed to compile contract artifacts: %v", err))
+	}
+	stopWatchers := make(chan struct{})
+	defer close(stopWatchers)
+	go modules.Watch(ARTIFACTS_POLL_INTERVAL, stopWatchers)
+	go watchRatings(EventBus, durationFromEnv("RATING_POLL_INTERVAL", DEFAULT_RATING_POLL_INTERVAL), stopWatchers)
+
+	deployments, err := LoadContractDeployments(DEPLOYED_CONTRACTS_FILE)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load contract deployments: %v", err))
+	}
+
+	server := &Server{
+		Credits:     credits.NewLedger(db),
+		Modules:     modules,
+		Deployments: deployments,
+		Callbacks:   NewCallbackIndex(executionDB),
+		Journal:     NewExecutionJournal(executionDB),
+		NodeSigner:  nodeSignerFromEnv(),
+		CreditPrice: creditPriceFromEnv(),
+	}
+	// The jobs which did not finish before the last stop are lost, their
+	// callbacks are executed again when retried
+	if forgotten, err := server.Callbacks.Recover(); err != nil {
+		panic(fmt.Sprintf("failed to recover the callback index: %v", err))
+	} else if forgotten > 0 {
+		fmt.Printf("%d callbacks left unfinished by the last run will be executed again when retried\n", forgotten)
+	}
+	server.Jobs = NewJobQueue(server, contractWorkerCount())
 
 	r := gin.Default()
 
@@ -65,37 +117,68 @@ func main() {
 	r.GET("/ws", func(c *gin.Context) {
 		handleSocketConnection(c.Writer, c.Request)
 	})
+	r.GET("/events", func(c *gin.Context) {
+		handleEventsConnection(c.Writer, c.Request)
+	})
 	r.GET("/connected-clients", server.handleConnectedClients)
 	r.GET("/ping-client", server.handlePingClient)
+	server.registerSessionRoutes(r)
 
-	r.POST("/api/upload_asset", server.handleUploadAsset)
+	r.POST("/api/upload_asset", server.contractHandler("upload_asset"))
 	r.POST("/api/upload_asset/upload_artifacts", server.handleUploadAsset_UploadArtifacts)
 	r.GET("/api/upload_asset/get_artifact_info_by_cid/:cid", cache.CachePage(cacheStore, 12*time.Hour, server.handleUploadAsset_GetArtifactInfo))
 	r.GET("/api/upload_asset/get_artifact_file_name/:cid", cache.CachePage(cacheStore, 12*time.Hour, server.handleUploadAsset_GetArtifactFileName))
 
-	r.POST("/api/use_asset", server.handleUseAsset)
+	r.POST("/api/use_asset", server.contractHandler("use_asset"))
 	r.GET("/api/download_artifact/:cid", server.handleDownloadArtifact)
 
-	r.POST("/api/pay_for_inference", server.handlePayForInference)
+	r.POST("/api/pay_for_inference", server.contractHandler("pay_for_inference"))
 
-	r.POST("/api/onboard_infra_provider", server.handleUserOnboarding)
+	r.POST("/api/transfer_asset", server.contractHandler("transfer_asset"))
+
+	r.POST("/api/onboard_infra_provider", server.contractHandler("onboard_infra_provider"))
 	r.GET("/api/onboarded_providers", server.handleOnboardedProviders)
 
 	// NEW ENDPOINT FOR CREATE TOKEN
-	r.POST("/api/create_token", server.handleCreateToken)
+	r.POST("/api/create_token", server.contractHandler("create_token"))
 
 	// Metrics
 	r.GET("/metrics/asset_count", server.handleMetricsAssetCount)
 	r.GET("/metrics/transaction_count", cache.CachePage(cacheStore, 30*time.Second, server.handleMetricsTransactionCount))
 
+	// Contract execution
+	r.POST("/api/contracts/:name/execute", server.handleExecuteContract)
+	r.POST("/api/contracts/:name/simulate", server.handleSimulateContract)
+	r.GET("/api/executions", server.handleListExecutions)
+	r.GET("/api/executions/:id", server.handleGetExecution)
+	r.GET("/api/jobs/:id", server.handleGetJob)
+
 	r.GET("/api/get_rating_by_asset", server.GetRatingsFromChain)
 	
 	// Credits Balance Contract Callback
 	r.GET("/api/credit_balance/:did", server.handleGetCreditBalance)
-	r.POST("/api/add_credits", server.handleAddCredits)
-	r.POST("/api/deduct_credits", server.handleDeductCredits)
-
-	r.Run(":8082")
+	r.POST("/api/add_credits", server.contractHandler("add_credits"))
+	// Deducting credits outside of a contract is left to operators
+	r.POST("/api/deduct_credits", requireAdmin(), server.handleDeductCredits)
+
+	httpServer := &http.Server{Addr: ":8082", Handler: r}
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.ListenAndServe()
+	}()
+
+	// The dapp runs until it is interrupted or terminated. A second signal
+	// kills it without waiting for the shutdown.
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-signals.Done():
+	case err := <-served:
+		fmt.Printf("server stopped, err: %v\n", err)
+	}
+	stopSignals()
+
+	// The databases are closed once the executions are drained
+	server.shutdown(httpServer, durationFromEnv("SHUTDOWN_TIMEOUT", DEFAULT_SHUTDOWN_TIMEOUT))
 }
 
 func wrapError(f func(code int, obj any), msg string) {
@@ -262,278 +345,6 @@ func GetRatingFromChain(assetID string) (float64, int, error) {
 	return average, user_count, nil
 }
 
-func (s *Server) handleUploadAsset(c *gin.Context) {
-	nodeAddress := "http://localhost:20007"
-	quorumType := 2
-
-	selfContractHashPath := path.Join("../artifacts/asset_publish_contract.wasm")
-
-	var contractInputRequest ContractInputRequest
-
-	err := json.NewDecoder(c.Request.Body).Decode(&contractInputRequest)
-	if err != nil {
-		wrapError(c.JSON, "err: Invalid request body")
-		return
-	}
-
-	trieConn, ok := TrieClientsMap[contractInputRequest.InitiatorDID]
-	if !ok {
-		wrapError(c.JSON, fmt.Sprintf("clientID %s not found", contractInputRequest.InitiatorDID))
-		return
-	}
-
-	wasmCtx := wasmContext.NewWasmContext().WithExternalSocketConn(trieConn)
-
-	// Create Import function registry
-	hostFnRegistry := wasmbridge.NewHostFunctionRegistry()
-	hostFnRegistry.Register(ft.NewDoTransferFTApiCall())
-	hostFnRegistry.Register(nft.NewDoMintNFTApiCall())
-	hostFnRegistry.Register(ft.NewDoCreateFTApiCall())
-
-	// Initialize the WASM module
-	wasmModule, err := wasmbridge.NewWasmModule(
-		selfContractHashPath,
-		hostFnRegistry,
-		wasmbridge.WithRubixNodeAddress(nodeAddress),
-		wasmbridge.WithQuorumType(quorumType),
-		wasmbridge.WithWasmContext(wasmCtx),
-	)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to initialize wasmModule: %v", err))
-		return
-	}
-
-	if contractInputRequest.SmartContractData == "" {
-		wrapError(c.JSON, fmt.Sprintf("unable to fetch Smart Contract from callback"))
-		return
-	}
-
-	_, err = wasmModule.CallFunction(contractInputRequest.SmartContractData)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to execute function, err: %v", err))
-		return
-	}
-}
-
-func (s *Server) handlePayForInference(c *gin.Context) {
-	nodeAddress := "http://localhost:20007"
-	quorumType := 2
-
-	selfContractHashPath := path.Join("../artifacts/inference_contract.wasm")
-
-	var contractInputRequest ContractInputRequest
-
-	err := json.NewDecoder(c.Request.Body).Decode(&contractInputRequest)
-	if err != nil {
-		wrapError(c.JSON, "err: Invalid request body")
-		return
-	}
-
-	trieConn, ok := TrieClientsMap[contractInputRequest.InitiatorDID]
-	if !ok {
-		wrapError(c.JSON, fmt.Sprintf("clientID %s not found", contractInputRequest.InitiatorDID))
-		return
-	}
-
-	wasmCtx := wasmContext.NewWasmContext().WithExternalSocketConn(trieConn)
-
-	// Create Import function registry
-	hostFnRegistry := wasmbridge.NewHostFunctionRegistry()
-	hostFnRegistry.Register(ft.NewDoTransferFTApiCall())
-	hostFnRegistry.Register(nft.NewDoExecuteNFT())
-
-	// Initialize the WASM module
-	wasmModule, err := wasmbridge.NewWasmModule(
-		selfContractHashPath,
-		hostFnRegistry,
-		wasmbridge.WithRubixNodeAddress(nodeAddress),
-		wasmbridge.WithQuorumType(quorumType),
-		wasmbridge.WithWasmContext(wasmCtx),
-	)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to initialize wasmModule: %v", err))
-		return
-	}
-
-	if contractInputRequest.SmartContractData == "" {
-		wrapError(c.JSON, fmt.Sprintf("unable to fetch Smart Contract from callback"))
-		return
-	}
-
-	_, err = wasmModule.CallFunction(contractInputRequest.SmartContractData)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to execute function, err: %v", err))
-		return
-	}
-}
-
-func (s *Server) handleUseAsset(c *gin.Context) {
-	nodeAddress := "http://localhost:20007"
-	quorumType := 2
-
-	selfContractHashPath := path.Join("../artifacts/asset_usage_contract.wasm")
-
-	var contractInputRequest ContractInputRequest
-
-	err := json.NewDecoder(c.Request.Body).Decode(&contractInputRequest)
-	if err != nil {
-		wrapError(c.JSON, "err: Invalid request body")
-		return
-	}
-
-	trieConn, ok := TrieClientsMap[contractInputRequest.InitiatorDID]
-	if !ok {
-		wrapError(c.JSON, fmt.Sprintf("clientID %s not found", contractInputRequest.InitiatorDID))
-		return
-	}
-
-	wasmCtx := wasmContext.NewWasmContext().WithExternalSocketConn(trieConn)
-
-	// Create Import function registry
-	hostFnRegistry := wasmbridge.NewHostFunctionRegistry()
-	hostFnRegistry.Register(ft.NewDoTransferFTApiCall())
-	hostFnRegistry.Register(nft.NewDoExecuteNFT())
-	hostFnRegistry.Register(ft.NewDoCreateFTApiCall())
-
-	// Initialize the WASM module
-	wasmModule, err := wasmbridge.NewWasmModule(
-		selfContractHashPath,
-		hostFnRegistry,
-		wasmbridge.WithRubixNodeAddress(nodeAddress),
-		wasmbridge.WithQuorumType(quorumType),
-		wasmbridge.WithWasmContext(wasmCtx),
-	)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to initialize wasmModule: %v", err))
-		return
-	}
-
-	if contractInputRequest.SmartContractData == "" {
-		wrapError(c.JSON, fmt.Sprintf("unable to fetch Smart Contract from callback"))
-		return
-	}
-
-	_, err = wasmModule.CallFunction(contractInputRequest.SmartContractData)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to execute function, err: %v", err))
-		return
-	}
-}
-
-// NEW HANDLER FOR CREATE TOKEN
-func (s *Server) handleCreateToken(c *gin.Context) {
-	nodeAddress := "http://localhost:20007"
-	quorumType := 2
-
-	// Use the existing WASM file that contains CREATE_FT functionality
-	selfContractHashPath := path.Join("../artifacts/asset_create_ft.wasm")
-
-	var contractInputRequest ContractInputRequest
-
-	err := json.NewDecoder(c.Request.Body).Decode(&contractInputRequest)
-	if err != nil {
-		wrapError(c.JSON, "err: Invalid request body")
-		return
-	}
-	fmt.Println("The value fo Initator DID: ", contractInputRequest.InitiatorDID)
-
-	trieConn, ok := TrieClientsMap[contractInputRequest.InitiatorDID]
-	if !ok {
-		wrapError(c.JSON, fmt.Sprintf("clientID %s not found", contractInputRequest.InitiatorDID))
-		return
-	}
-
-	wasmCtx := wasmContext.NewWasmContext().WithExternalSocketConn(trieConn)
-
-	// Create Import function registry - only register what's needed for token creation
-	hostFnRegistry := wasmbridge.NewHostFunctionRegistry()
-	hostFnRegistry.Register(ft.NewDoCreateFTApiCall())
-
-	// Initialize the WASM module
-	wasmModule, err := wasmbridge.NewWasmModule(
-		selfContractHashPath,
-		hostFnRegistry,
-		wasmbridge.WithRubixNodeAddress(nodeAddress),
-		wasmbridge.WithQuorumType(quorumType),
-		wasmbridge.WithWasmContext(wasmCtx),
-	)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to initialize wasmModule: %v", err))
-		return
-	}
-
-	if contractInputRequest.SmartContractData == "" {
-		wrapError(c.JSON, fmt.Sprintf("unable to fetch Smart Contract from callback"))
-		return
-	}
-
-	result, err := wasmModule.CallFunction(contractInputRequest.SmartContractData)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to execute function, err: %v", err))
-		return
-	}
-
-	wrapSuccess(c.JSON, result)
-}
-
-func (s *Server) handleUserOnboarding(c *gin.Context) {
-	nodeAddress := "http://localhost:20007"
-	quorumType := 2
-
-	selfContractHashPath := path.Join("../artifacts/onboarding_contract.wasm")
-
-	var contractInputRequest ContractInputRequest
-
-	err := json.NewDecoder(c.Request.Body).Decode(&contractInputRequest)
-	if err != nil {
-		wrapError(c.JSON, "err: Invalid request body")
-		return
-	}
-
-	// Create Import function registry
-	hostFnRegistry := wasmbridge.NewHostFunctionRegistry()
-	hostFnRegistry.Register(onboarding.NewVerifyAction())
-	// Initialize the WASM module
-	wasmModule, err := wasmbridge.NewWasmModule(
-		selfContractHashPath,
-		hostFnRegistry,
-		wasmbridge.WithRubixNodeAddress(nodeAddress),
-		wasmbridge.WithQuorumType(quorumType),
-	)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to initialize wasmModule: %v", err))
-		return
-	}
-
-	if contractInputRequest.SmartContractData == "" {
-		wrapError(c.JSON, fmt.Sprintf("unable to fetch Smart Contract from callback"))
-		return
-	}
-
-	contractResult, err := wasmModule.CallFunction(contractInputRequest.SmartContractData)
-	if err != nil {
-		wrapError(c.JSON, fmt.Sprintf("unable to execute function, err: %v", err))
-		return
-	}
-
-	msg, errMsg := extractSignatureVerificationOutput(contractResult)
-	if errMsg != "" {
-		wrapError(c.JSON, fmt.Sprintf("error occured while verifying the signature, err: %v", errMsg))
-		return
-	}
-
-	switch msg {
-	case "Success":
-		wrapSuccess(c.JSON, "signature is valid")
-		return
-	case "Fail":
-		wrapSuccess(c.JSON, "signature is invalid")
-		return
-	default:
-		wrapError(c.JSON, fmt.Sprintf("unexpected error occured while retrieving the signature verification result, msg val extracted: %v", msg))
-	}
-}
-
 func (s *Server) handleOnboardedProviders(c *gin.Context) {
 	w := http.ResponseWriter(c.Writer)
 	enableCors(&w)
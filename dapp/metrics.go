@@ -120,6 +120,32 @@ func listSmartContractTransactions(contractId string) (*SmartContractDataRespons
 	return transactions, nil
 }
 
+// listNFTOwners returns the owner DID of every NFT known to the Rubix node,
+// keyed by NFT ID
+func listNFTOwners() (map[string]string, error) {
+	targetURL, err := url.JoinPath(RUBIX_API, "/api/list-nfts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct URL: %w", err)
+	}
+
+	response, err := queryRubixNode(targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch NFT tokens: %w", err)
+	}
+
+	var assetCountResponse AssetCountResponse
+	if err := json.Unmarshal([]byte(response), &assetCountResponse); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal response: %w", err)
+	}
+
+	owners := make(map[string]string, len(assetCountResponse.Nfts))
+	for _, nft := range assetCountResponse.Nfts {
+		owners[nft.Nft] = nft.OwnerDID
+	}
+
+	return owners, nil
+}
+
 func listNFTTransactionsByID(nftId string) (*NFTTransactionList, error) {
 	baseURL, err := url.Parse(RUBIX_API)
 	if err != nil {
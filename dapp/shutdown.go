@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DEFAULT_SHUTDOWN_TIMEOUT is how long the contract runs in flight are
+// drained for on shutdown, unless set by SHUTDOWN_TIMEOUT
+const DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+// SOCKET_CLOSE_WAIT bounds the wait for the socket handlers to return once
+// their sockets are closed
+const SOCKET_CLOSE_WAIT = 5 * time.Second
+
+// shutdown stops the dapp within timeout. New executions are refused right
+// away and the clients are told about the shutdown. The HTTP requests and
+// contract runs in flight are then drained until the deadline, past which
+// the runs still going are cancelled. The sockets are closed last, so that
+// the wallets can sign the commands of the runs being drained, before the
+// epoch ticker of the contract modules is stopped.
+func (s *Server) shutdown(httpServer *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	fmt.Printf("Shutting down, draining contract executions until %v\n", deadline.Format(time.RFC3339))
+
+	s.Jobs.Close()
+	Sockets.Broadcast(MESSAGE_SHUTDOWN, gin.H{
+		"message":  ErrShuttingDown.Error(),
+		"deadline": deadline,
+	})
+
+	httpDone := make(chan error, 1)
+	go func() {
+		httpDone <- httpServer.Shutdown(ctx)
+	}()
+
+	if err := s.Jobs.Drain(ctx); err != nil {
+		fmt.Printf("contract executions not drained, err: %v\n", err)
+	}
+	if err := <-httpDone; err != nil {
+		fmt.Printf("HTTP requests not drained, err: %v\n", err)
+	}
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), SOCKET_CLOSE_WAIT)
+	defer closeCancel()
+
+	if err := Sockets.CloseAll(closeCtx); err != nil {
+		fmt.Printf("sockets not closed, err: %v\n", err)
+	}
+
+	s.Modules.Close()
+
+	fmt.Println("Shutdown complete")
+}
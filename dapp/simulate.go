@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"dapp/wallet"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SimulationResult is the outcome of a dry run of a contract. Actions lists
+// the host functions called by the contract, along with the wallet commands
+// each of them would have sent.
+type SimulationResult struct {
+	Contract          string            `json:"contract"`
+	SmartContractHash string            `json:"smart_contract_hash"`
+	InitiatorDID      string            `json:"initiator_did"`
+	Actions           []*HostCallRecord `json:"actions"`
+	Output            string            `json:"output,omitempty"`
+	Error             string            `json:"error,omitempty"`
+}
+
+// simulateContract runs the registered contract name without reaching the
+// wallet or the chain. Every wallet command is approved with a canned reply
+// and nothing is recorded in the execution journal.
+func (s *Server) simulateContract(ctx context.Context, name string, contractInputRequest *ContractInputRequest) (*SimulationResult, error) {
+	spec, ok := contractRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("contract %s is not registered", name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, spec.timeout())
+	defer cancel()
+
+	exec := newExecution(ctx, name, contractInputRequest)
+	exec.Simulated = true
+	exec.UseSigner(wallet.NewSimulatedSigner())
+
+	output, err := s.runContract(spec, exec, contractInputRequest)
+
+	result := &SimulationResult{
+		Contract:          name,
+		SmartContractHash: contractInputRequest.SmartContractHash,
+		InitiatorDID:      contractInputRequest.InitiatorDID,
+		Actions:           exec.Record.HostCalls,
+		Output:            output,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result, nil
+}
+
+func (s *Server) handleSimulateContract(c *gin.Context) {
+	w := http.ResponseWriter(c.Writer)
+	enableCors(&w)
+
+	var contractInputRequest ContractInputRequest
+
+	err := json.NewDecoder(c.Request.Body).Decode(&contractInputRequest)
+	if err != nil {
+		wrapError(c.JSON, "err: Invalid request body")
+		return
+	}
+
+	result, err := s.simulateContract(c.Request.Context(), c.Param("name"), &contractInputRequest)
+	if err != nil {
+		wrapError(c.JSON, err.Error())
+		return
+	}
+
+	// The actions taken before a failure are still reported
+	if result.Error != "" {
+		c.JSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
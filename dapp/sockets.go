@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"dapp/wallet"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// MESSAGE_SHUTDOWN tells the clients that the dapp is shutting down, before
+// their sockets are closed
+const MESSAGE_SHUTDOWN = "SHUTDOWN"
+
+// Sockets keeps track of every socket served by the dapp
+var Sockets = NewSocketManager()
+
+// SocketManager keeps track of the brokers owning the open sockets, those of
+// the wallet sessions and of the event streams alike, so that their clients
+// can be notified and their sockets closed when the dapp shuts down. The
+// broker of a socket is its only reader, and its handler returns once the
+// broker is closed.
+type SocketManager struct {
+	mu       sync.Mutex
+	brokers  map[*wallet.Broker]struct{}
+	closing  bool
+	handlers sync.WaitGroup
+}
+
+func NewSocketManager() *SocketManager {
+	return &SocketManager{
+		brokers: make(map[*wallet.Broker]struct{}),
+	}
+}
+
+// Track keeps track of broker until release is called, which its handler
+// does once the broker is done. Brokers are refused and closed right away
+// once the dapp is shutting down.
+func (m *SocketManager) Track(broker *wallet.Broker) (release func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closing {
+		broker.CloseWith(websocket.CloseGoingAway, ErrShuttingDown.Error())
+		return nil, ErrShuttingDown
+	}
+
+	m.brokers[broker] = struct{}{}
+	m.handlers.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			delete(m.brokers, broker)
+			m.mu.Unlock()
+
+			m.handlers.Done()
+		})
+	}, nil
+}
+
+// Count returns the number of sockets tracked
+func (m *SocketManager) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.brokers)
+}
+
+// Broadcast pushes a notification to the client of every socket. Clients
+// which are disconnected miss it.
+func (m *SocketManager) Broadcast(msgType string, data interface{}) {
+	m.mu.Lock()
+	brokers := make([]*wallet.Broker, 0, len(m.brokers))
+	for broker := range m.brokers {
+		brokers = append(brokers, broker)
+	}
+	m.mu.Unlock()
+
+	for _, broker := range brokers {
+		err := broker.Push(msgType, data)
+		if err != nil && !errors.Is(err, wallet.ErrDisconnected) && !errors.Is(err, wallet.ErrConnectionClosed) {
+			fmt.Printf("unable to push %v, err: %v\n", msgType, err)
+		}
+	}
+}
+
+// CloseAll refuses new sockets, closes every socket and waits until ctx is
+// done for their handlers to return. The pending commands of the wallet
+// sessions fail.
+func (m *SocketManager) CloseAll(ctx context.Context) error {
+	m.mu.Lock()
+	m.closing = true
+	brokers := make([]*wallet.Broker, 0, len(m.brokers))
+	for broker := range m.brokers {
+		brokers = append(brokers, broker)
+	}
+	m.mu.Unlock()
+
+	for _, broker := range brokers {
+		broker.CloseWith(websocket.CloseGoingAway, ErrShuttingDown.Error())
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		m.handlers.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%v socket handlers did not return, err: %w", m.Count(), ctx.Err())
+	}
+}
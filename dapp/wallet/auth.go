@@ -0,0 +1,108 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// MESSAGE_CHALLENGE asks the wallet to prove that it holds the key of
+	// the DID it connects as
+	MESSAGE_CHALLENGE = "CHALLENGE"
+	// MESSAGE_CHALLENGE_RESPONSE carries the signature of the challenge
+	MESSAGE_CHALLENGE_RESPONSE = "CHALLENGE_RESPONSE"
+)
+
+// ERROR_CODE_AUTH_FAILED is sent in the ERROR message which rejects a wallet
+// failing the challenge, before the connection is closed
+const ERROR_CODE_AUTH_FAILED = "AUTH_FAILED"
+
+// CHALLENGE_TIMEOUT is how long the wallet has to answer the challenge
+const CHALLENGE_TIMEOUT = 30 * time.Second
+
+// Challenge is sent to a wallet right after it opens its connection. The
+// wallet signs Message with the private key of DID.
+type Challenge struct {
+	DID     string `json:"did"`
+	Message string `json:"message"`
+}
+
+type ChallengeResponse struct {
+	Signature string `json:"signature"`
+}
+
+// VerifyFunc checks that signature is a hex encoded signature of message
+// made with the private key of did
+type VerifyFunc func(did string, message string, signature string) error
+
+// Authenticate challenges the wallet connected over conn to sign a random
+// nonce with the key of did, and checks the signature with verify. The
+// wallet is sent an ERROR message when it fails the challenge, and it is up
+// to the caller to close conn.
+func Authenticate(conn *websocket.Conn, did string, verify VerifyFunc) error {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("unable to generate a challenge for %v, err: %v", did, err)
+	}
+
+	challengeID := newMessageID()
+	challenge := &Challenge{
+		DID:     did,
+		Message: hex.EncodeToString(nonce),
+	}
+
+	challengeBytes, err := marshalEnvelope(challengeID, MESSAGE_CHALLENGE, challenge)
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, challengeBytes); err != nil {
+		return fmt.Errorf("unable to send the challenge to %v, err: %v", did, err)
+	}
+
+	err = readChallengeResponse(conn, challengeID, challenge, verify)
+	if err != nil {
+		rejectBytes, _ := json.Marshal(&Envelope{
+			Version: PROTOCOL_VERSION,
+			ID:      challengeID,
+			Type:    MESSAGE_ERROR,
+			Code:    ERROR_CODE_AUTH_FAILED,
+			Error:   err.Error(),
+		})
+		conn.WriteMessage(websocket.TextMessage, rejectBytes)
+	}
+
+	return err
+}
+
+func readChallengeResponse(conn *websocket.Conn, challengeID string, challenge *Challenge, verify VerifyFunc) error {
+	conn.SetReadDeadline(time.Now().Add(CHALLENGE_TIMEOUT))
+	defer conn.SetReadDeadline(time.Time{})
+
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("no response to the challenge of %v, err: %v", challenge.DID, err)
+	}
+
+	envelope, err := parseEnvelope(msg)
+	if err != nil {
+		return err
+	}
+	if envelope.Type != MESSAGE_CHALLENGE_RESPONSE || envelope.ID != challengeID {
+		return fmt.Errorf("expected a %v message for challenge %v, got %v %q", MESSAGE_CHALLENGE_RESPONSE, challengeID, envelope.Type, envelope.ID)
+	}
+
+	var response ChallengeResponse
+	if err := json.Unmarshal(envelope.Data, &response); err != nil {
+		return fmt.Errorf("malformed response to the challenge of %v, err: %v", challenge.DID, err)
+	}
+	if response.Signature == "" {
+		return fmt.Errorf("the response to the challenge of %v carries no signature", challenge.DID)
+	}
+
+	return verify(challenge.DID, challenge.Message, response.Signature)
+}
@@ -0,0 +1,716 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const COMMAND_QUEUE_SIZE = 32
+const PING_WRITE_TIMEOUT = 5 * time.Second
+
+var ErrConnectionClosed = errors.New("wallet connection closed")
+var ErrDisconnected = errors.New("wallet is disconnected, waiting for it to reconnect")
+var ErrCommandCancelled = errors.New("command cancelled by an operator")
+
+type commandResult struct {
+	reply []byte
+	err   error
+}
+
+type pendingCommand struct {
+	id       string
+	action   string
+	msg      []byte
+	queuedAt time.Time
+
+	once    sync.Once
+	result  chan commandResult
+	settled chan struct{}
+}
+
+// settle delivers the outcome of the command. Only the first outcome is kept,
+// as the reader and the closing of the connection may race to settle it.
+func (cmd *pendingCommand) settle(result commandResult) {
+	cmd.once.Do(func() {
+		cmd.result <- result
+		close(cmd.settled)
+	})
+}
+
+// connection is one WebSocket connection of the wallet session served by a
+// broker. A broker goes through several of them when the wallet reconnects.
+type connection struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+
+	lost     chan struct{}
+	lostOnce sync.Once
+}
+
+func (c *connection) write(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.ws.WriteMessage(messageType, data)
+}
+
+func (c *connection) drop() {
+	c.lostOnce.Do(func() {
+		close(c.lost)
+		c.ws.Close()
+	})
+}
+
+type BrokerOption func(b *Broker)
+
+// MessageHandler serves a request sent by the wallet on its own initiative,
+// such as a subscription to events. Its result is sent back in a REPLY, or
+// its error in an ERROR, carrying the ID of the request.
+type MessageHandler func(data json.RawMessage) (interface{}, error)
+
+// WithHeartbeat pings the wallet every interval, and drops the connection
+// when nothing is received from the wallet for timeout
+func WithHeartbeat(interval time.Duration, timeout time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.pingInterval = interval
+		b.pongTimeout = timeout
+	}
+}
+
+// WithReconnectGrace keeps the broker and its pending commands for grace
+// after the connection is lost, so that the wallet can reconnect and resume
+// them with Attach. Without it, the broker closes with its connection.
+func WithReconnectGrace(grace time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.grace = grace
+	}
+}
+
+// WithMessageHandler serves the messages of type msgType sent by the wallet
+// with handler
+func WithMessageHandler(msgType string, handler MessageHandler) BrokerOption {
+	return func(b *Broker) {
+		if b.handlers == nil {
+			b.handlers = make(map[string]MessageHandler)
+		}
+		b.handlers[msgType] = handler
+	}
+}
+
+// WithSchema validates the commands sent to the wallet and its replies to
+// them against schema, the version negotiated in the OPEN handshake
+func WithSchema(schema *Schema) BrokerOption {
+	return func(b *Broker) {
+		b.schema = schema
+	}
+}
+
+// Broker serves a wallet session. It runs the only reader of the session's
+// connection, serializes all writes, and sends extension commands one at a
+// time. Replies are routed back to the waiting command by their ID.
+type Broker struct {
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	grace        time.Duration
+	handlers     map[string]MessageHandler
+	schema       *Schema
+
+	commands chan *pendingCommand
+
+	mu         sync.Mutex
+	conn       *connection
+	attached   chan struct{}
+	generation int
+	graceTimer *time.Timer
+	awaiting   *pendingCommand
+
+	// Bookkeeping of the session shown to operators
+	pending       map[string]*pendingCommand
+	lastMessageAt time.Time
+	completed     int
+	failed        int
+
+	done     chan struct{}
+	closeErr error
+}
+
+// NewBroker takes over conn and starts its reader and dispatcher goroutines
+func NewBroker(conn *websocket.Conn, opts ...BrokerOption) *Broker {
+	b := &Broker{
+		commands: make(chan *pendingCommand, COMMAND_QUEUE_SIZE),
+		attached: make(chan struct{}),
+		pending:  make(map[string]*pendingCommand),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.mu.Lock()
+	b.attachLocked(conn)
+	b.mu.Unlock()
+
+	go b.dispatchLoop()
+
+	return b
+}
+
+// Attach resumes the session on conn, a new connection of the wallet made
+// within the reconnect grace period, for which schema was negotiated. The
+// command awaiting a reply is sent again on conn.
+func (b *Broker) Attach(conn *websocket.Conn, schema *Schema) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The commands of the session were validated against its schema
+	if b.schema != nil && (schema == nil || schema.Version != b.schema.Version) {
+		return fmt.Errorf("wallet session uses schema v%v", b.schema.Version)
+	}
+
+	select {
+	case <-b.done:
+		return ErrConnectionClosed
+	default:
+	}
+
+	if b.conn != nil {
+		return fmt.Errorf("wallet session is still connected")
+	}
+
+	if b.graceTimer != nil {
+		b.graceTimer.Stop()
+		b.graceTimer = nil
+	}
+	b.attachLocked(conn)
+
+	return nil
+}
+
+func (b *Broker) attachLocked(ws *websocket.Conn) {
+	c := &connection{
+		ws:   ws,
+		lost: make(chan struct{}),
+	}
+
+	if b.pongTimeout > 0 {
+		ws.SetReadDeadline(time.Now().Add(b.pongTimeout))
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(b.pongTimeout))
+		})
+	}
+
+	b.conn = c
+	b.generation++
+
+	// Wake up everything waiting for the wallet to reconnect
+	close(b.attached)
+	b.attached = make(chan struct{})
+
+	go b.readLoop(c)
+	if b.pingInterval > 0 {
+		go b.heartbeat(c)
+	}
+}
+
+// Schema returns the schema the session was opened with, nil if its
+// messages are not validated
+func (b *Broker) Schema() *Schema {
+	return b.schema
+}
+
+// Connected reports whether the wallet is currently connected
+func (b *Broker) Connected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.conn != nil
+}
+
+// connection returns the current connection, or nil along with a channel
+// closed once the wallet reconnects
+func (b *Broker) connection() (*connection, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.conn, b.attached
+}
+
+// lose drops the connection c. The broker is closed unless the wallet
+// reconnects within the grace period.
+func (b *Broker) lose(c *connection, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c.drop()
+	if b.conn != c {
+		return
+	}
+	b.conn = nil
+
+	if b.grace <= 0 {
+		b.closeLocked(err)
+		return
+	}
+
+	fmt.Printf("wallet connection lost, waiting %v for it to reconnect, err: %v\n", b.grace, err)
+	generation := b.generation
+	b.graceTimer = time.AfterFunc(b.grace, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.conn == nil && b.generation == generation {
+			b.closeLocked(fmt.Errorf("wallet did not reconnect within %v, err: %w", b.grace, err))
+		}
+	})
+}
+
+// Done is closed once the broker is closed
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
+}
+
+// Err returns the reason the broker was closed
+func (b *Broker) Err() error {
+	<-b.done
+	return b.closeErr
+}
+
+// Close closes the connection and fails the pending commands
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closeLocked(ErrConnectionClosed)
+}
+
+// CloseWith closes the broker like Close, telling the wallet why in a
+// WebSocket close frame with the given code
+func (b *Broker) CloseWith(code int, text string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn != nil {
+		b.conn.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(PING_WRITE_TIMEOUT))
+	}
+	b.closeLocked(ErrConnectionClosed)
+}
+
+func (b *Broker) closeLocked(err error) {
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+
+	b.closeErr = err
+	close(b.done)
+
+	if b.graceTimer != nil {
+		b.graceTimer.Stop()
+		b.graceTimer = nil
+	}
+	if b.conn != nil {
+		b.conn.drop()
+		b.conn = nil
+	}
+	if b.awaiting != nil {
+		b.awaiting.settle(commandResult{err: ErrConnectionClosed})
+		b.awaiting = nil
+	}
+}
+
+// Send queues an extension command and waits for the wallet's reply to it
+func (b *Broker) Send(action string, payload interface{}) ([]byte, error) {
+	return b.SendContext(context.Background(), action, payload)
+}
+
+// SendContext is like Send, but abandons the command once ctx is done. A
+// command abandoned before being sent is dropped from the queue, and a late
+// reply to a command abandoned after being sent is dropped on arrival.
+func (b *Broker) SendContext(ctx context.Context, action string, payload interface{}) ([]byte, error) {
+	// A payload which does not match the schema is a bug of the dapp, which
+	// is not reported as an outcome of the command
+	if b.schema != nil {
+		if err := b.schema.ValidatePayload(action, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	id := newMessageID()
+
+	msgBytes, err := marshalEnvelope(id, MESSAGE_OPEN_EXTENSION, &ExtensionCommand{
+		Action:  action,
+		Payload: payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal %v command, err: %v", action, err)
+	}
+
+	cmd := &pendingCommand{
+		id:       id,
+		action:   action,
+		msg:      msgBytes,
+		queuedAt: time.Now(),
+		result:   make(chan commandResult, 1),
+		settled:  make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.pending[id] = cmd
+	b.mu.Unlock()
+
+	var result commandResult
+	defer func() {
+		b.mu.Lock()
+		delete(b.pending, id)
+		if result.err == nil {
+			b.completed++
+		} else {
+			b.failed++
+		}
+		b.mu.Unlock()
+	}()
+
+	select {
+	case b.commands <- cmd:
+	case <-b.done:
+		result.err = ErrConnectionClosed
+		return nil, AsCommandError(action, result.err)
+	case <-ctx.Done():
+		result.err = fmt.Errorf("%v command abandoned, err: %w", action, ctx.Err())
+		return nil, AsCommandError(action, result.err)
+	case <-cmd.settled:
+		// Cancelled while waiting for room in the queue
+	}
+
+	// A command queued while the broker closes is never dispatched
+	select {
+	case <-cmd.settled:
+	case <-b.done:
+		cmd.settle(commandResult{err: ErrConnectionClosed})
+	case <-ctx.Done():
+		cmd.settle(commandResult{err: fmt.Errorf("%v command abandoned, err: %w", action, ctx.Err())})
+	}
+
+	// Every failure is reported as a CommandError, which tells the host
+	// function how to report it to the contract
+	result = <-cmd.result
+	if result.err != nil {
+		return nil, AsCommandError(action, result.err)
+	}
+	return result.reply, nil
+}
+
+// Cancel fails the pending command id with ErrCommandCancelled. A command
+// already sent is not withdrawn from the wallet, but its reply is dropped
+// and the next command is sent.
+func (b *Broker) Cancel(id string) error {
+	b.mu.Lock()
+	cmd, ok := b.pending[id]
+	b.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no pending command %v", id)
+	}
+
+	cmd.settle(commandResult{err: ErrCommandCancelled})
+	return nil
+}
+
+// PendingCommand describes a command which is not settled yet. Its state is
+// queued, awaiting_reply, or awaiting_reconnect when it was sent but the
+// wallet lost its connection.
+type PendingCommand struct {
+	ID         string    `json:"id"`
+	Action     string    `json:"action"`
+	State      string    `json:"state"`
+	QueuedAt   time.Time `json:"queued_at"`
+	AgeSeconds float64   `json:"age_seconds"`
+}
+
+// BrokerStats is the state of a session shown to operators
+type BrokerStats struct {
+	RemoteAddr    string           `json:"remote_addr,omitempty"`
+	LastMessageAt *time.Time       `json:"last_message_at,omitempty"`
+	Pending       []PendingCommand `json:"pending"`
+	Completed     int              `json:"completed"`
+	Failed        int              `json:"failed"`
+}
+
+// Stats returns the current state of the session: the address of the
+// wallet, when it last sent a message, the pending commands oldest first,
+// and the number of commands which succeeded or failed
+func (b *Broker) Stats() BrokerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	stats := BrokerStats{
+		Pending:   make([]PendingCommand, 0, len(b.pending)),
+		Completed: b.completed,
+		Failed:    b.failed,
+	}
+	if b.conn != nil {
+		stats.RemoteAddr = b.conn.ws.RemoteAddr().String()
+	}
+	if !b.lastMessageAt.IsZero() {
+		lastMessageAt := b.lastMessageAt
+		stats.LastMessageAt = &lastMessageAt
+	}
+
+	for _, cmd := range b.pending {
+		state := "queued"
+		if cmd == b.awaiting {
+			state = "awaiting_reply"
+			if b.conn == nil {
+				state = "awaiting_reconnect"
+			}
+		}
+		stats.Pending = append(stats.Pending, PendingCommand{
+			ID:         cmd.id,
+			Action:     cmd.action,
+			State:      state,
+			QueuedAt:   cmd.queuedAt,
+			AgeSeconds: now.Sub(cmd.queuedAt).Seconds(),
+		})
+	}
+	sort.Slice(stats.Pending, func(i, j int) bool {
+		return stats.Pending[i].QueuedAt.Before(stats.Pending[j].QueuedAt)
+	})
+
+	return stats
+}
+
+// Push sends a notification of the given type, which expects no reply.
+// Notifications are not kept while the wallet is disconnected.
+func (b *Broker) Push(msgType string, data interface{}) error {
+	c, _ := b.connection()
+	if c == nil {
+		select {
+		case <-b.done:
+			return ErrConnectionClosed
+		default:
+			return ErrDisconnected
+		}
+	}
+
+	msgBytes, err := marshalEnvelope("", msgType, data)
+	if err != nil {
+		return err
+	}
+
+	return c.write(websocket.TextMessage, msgBytes)
+}
+
+// Ping sends a WebSocket ping frame
+func (b *Broker) Ping(data []byte) error {
+	c, _ := b.connection()
+	if c == nil {
+		return ErrDisconnected
+	}
+
+	return c.ws.WriteControl(websocket.PingMessage, data, time.Now().Add(PING_WRITE_TIMEOUT))
+}
+
+// heartbeat pings the wallet over c until c is lost. A ping which cannot be
+// written drops the connection, while a missing pong is noticed by the
+// reader when its deadline passes.
+func (b *Broker) heartbeat(c *connection) {
+	ticker := time.NewTicker(b.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.lost:
+			return
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(PING_WRITE_TIMEOUT)); err != nil {
+				b.lose(c, fmt.Errorf("heartbeat failed, err: %v", err))
+				return
+			}
+		}
+	}
+}
+
+// dispatchLoop sends the queued commands one at a time, waiting for the
+// outcome of a command before sending the next one
+func (b *Broker) dispatchLoop() {
+	for {
+		select {
+		case <-b.done:
+			b.failQueued()
+			return
+		case cmd := <-b.commands:
+			b.dispatch(cmd)
+		}
+	}
+}
+
+func (b *Broker) dispatch(cmd *pendingCommand) {
+	// Commands abandoned while queued are not sent
+	select {
+	case <-cmd.settled:
+		return
+	default:
+	}
+
+	// The command is marked as awaiting before it is written, so that
+	// a quick reply is not mistaken for an unsolicited message
+	b.mu.Lock()
+	b.awaiting = cmd
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		if b.awaiting == cmd {
+			b.awaiting = nil
+		}
+		b.mu.Unlock()
+	}()
+
+	for {
+		c, attached := b.connection()
+		if c == nil {
+			select {
+			case <-attached:
+				continue
+			case <-cmd.settled:
+				return
+			case <-b.done:
+				return
+			}
+		}
+
+		// The command is written again on every new connection, as the
+		// wallet may have lost it along with the previous one. It keeps
+		// its ID, so that the wallet can tell it was already received.
+		if err := c.write(websocket.TextMessage, cmd.msg); err != nil {
+			b.lose(c, fmt.Errorf("error occured while sending %v command, err: %v", cmd.action, err))
+			continue
+		}
+
+		// The next command is sent once this one is replied to or abandoned
+		select {
+		case <-cmd.settled:
+			return
+		case <-b.done:
+			return
+		case <-c.lost:
+		}
+	}
+}
+
+func (b *Broker) failQueued() {
+	for {
+		select {
+		case cmd := <-b.commands:
+			cmd.settle(commandResult{err: ErrConnectionClosed})
+		default:
+			return
+		}
+	}
+}
+
+// readLoop reads every message of c until it is lost. Every message counts
+// as a sign of life of the wallet, as pongs do.
+func (b *Broker) readLoop(c *connection) {
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			b.lose(c, err)
+			return
+		}
+
+		if b.pongTimeout > 0 {
+			c.ws.SetReadDeadline(time.Now().Add(b.pongTimeout))
+		}
+
+		b.mu.Lock()
+		b.lastMessageAt = time.Now()
+		b.mu.Unlock()
+
+		envelope, err := parseEnvelope(msg)
+		if err != nil {
+			fmt.Printf("dropping wallet message, err: %v, message: %s\n", err, msg)
+			continue
+		}
+
+		b.handle(c, envelope)
+	}
+}
+
+// handle routes a message of the wallet to its handler, or to the command
+// it refers to
+func (b *Broker) handle(c *connection, envelope *Envelope) {
+	if handler, ok := b.handlers[envelope.Type]; ok {
+		b.serve(c, envelope, handler)
+		return
+	}
+
+	b.mu.Lock()
+	cmd := b.awaiting
+	b.mu.Unlock()
+
+	if cmd == nil || envelope.ID == "" || envelope.ID != cmd.id {
+		fmt.Printf("dropping wallet %v message %q, which matches no pending command\n", envelope.Type, envelope.ID)
+		return
+	}
+
+	switch envelope.Type {
+	case MESSAGE_REPLY:
+		if b.schema != nil {
+			if err := b.schema.ValidateReply(cmd.action, envelope.Data); err != nil {
+				cmd.settle(commandResult{err: &CommandError{Action: cmd.action, Outcome: OutcomeWalletError, Reason: err.Error()}})
+				return
+			}
+		}
+
+		// A failed transaction relayed by the wallet is a wallet error,
+		// even though the command was approved
+		var response walletResponse
+		if err := json.Unmarshal(envelope.Data, &response); err == nil && response.Status != nil && !*response.Status {
+			cmd.settle(commandResult{err: &CommandError{Action: cmd.action, Outcome: OutcomeWalletError, Reason: response.Message}})
+			return
+		}
+		cmd.settle(commandResult{reply: envelope.Data})
+	case MESSAGE_ERROR:
+		cmd.settle(commandResult{err: commandErrorFromEnvelope(cmd.action, envelope)})
+	case MESSAGE_PROGRESS:
+		fmt.Printf("wallet is processing %v command %v: %s\n", cmd.action, cmd.id, envelope.Data)
+	default:
+		fmt.Printf("dropping wallet message of unknown type %v for %v command %v\n", envelope.Type, cmd.action, cmd.id)
+	}
+}
+
+// serve answers a request of the wallet on the connection it came from
+func (b *Broker) serve(c *connection, envelope *Envelope, handler MessageHandler) {
+	result, err := handler(envelope.Data)
+
+	var replyBytes []byte
+	if err != nil {
+		replyBytes, err = json.Marshal(&Envelope{
+			Version: PROTOCOL_VERSION,
+			ID:      envelope.ID,
+			Type:    MESSAGE_ERROR,
+			Code:    ERROR_CODE_INVALID_REQUEST,
+			Error:   err.Error(),
+		})
+	} else {
+		replyBytes, err = marshalEnvelope(envelope.ID, MESSAGE_REPLY, result)
+	}
+	if err != nil {
+		fmt.Printf("unable to answer wallet %v message %q, err: %v\n", envelope.Type, envelope.ID, err)
+		return
+	}
+
+	if err := c.write(websocket.TextMessage, replyBytes); err != nil {
+		b.lose(c, fmt.Errorf("error occured while answering %v message, err: %v", envelope.Type, err))
+	}
+}
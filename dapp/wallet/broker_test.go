@@ -0,0 +1,209 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// readCommand reads the next extension command sent to the wallet. It is
+// called by the goroutine of the wallet, so failures do not stop the test.
+func readCommand(t *testing.T, wallet *websocket.Conn) (*Envelope, bool) {
+	t.Helper()
+
+	wallet.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := wallet.ReadMessage()
+	if err != nil {
+		t.Errorf("no command received, err: %v", err)
+		return nil, false
+	}
+
+	envelope, err := parseEnvelope(msg)
+	if err != nil {
+		t.Error(err)
+		return nil, false
+	}
+	if envelope.Type != MESSAGE_OPEN_EXTENSION {
+		t.Errorf("received a %v message, want %v", envelope.Type, MESSAGE_OPEN_EXTENSION)
+		return nil, false
+	}
+	return envelope, true
+}
+
+func writeEnvelope(t *testing.T, wallet *websocket.Conn, envelope *Envelope) {
+	t.Helper()
+
+	envelope.Version = PROTOCOL_VERSION
+	msg, err := json.Marshal(envelope)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := wallet.WriteMessage(websocket.TextMessage, msg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBrokerReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// answer writes the messages of the wallet about the command id
+		answer  func(t *testing.T, wallet *websocket.Conn, id string)
+		reply   string
+		outcome Outcome
+	}{
+		{
+			name: "reply to the command",
+			answer: func(t *testing.T, wallet *websocket.Conn, id string) {
+				writeEnvelope(t, wallet, &Envelope{ID: id, Type: MESSAGE_REPLY, Data: json.RawMessage(`{"status":true,"message":"done"}`)})
+			},
+			reply:   `{"status":true,"message":"done"}`,
+			outcome: OutcomeApproved,
+		},
+		{
+			name: "replies to other commands are dropped",
+			answer: func(t *testing.T, wallet *websocket.Conn, id string) {
+				writeEnvelope(t, wallet, &Envelope{ID: "other", Type: MESSAGE_REPLY, Data: json.RawMessage(`{"status":true,"message":"other"}`)})
+				writeEnvelope(t, wallet, &Envelope{Type: MESSAGE_REPLY, Data: json.RawMessage(`{"status":true,"message":"no id"}`)})
+				writeEnvelope(t, wallet, &Envelope{ID: id, Type: MESSAGE_PROGRESS, Data: json.RawMessage(`{}`)})
+				writeEnvelope(t, wallet, &Envelope{ID: id, Type: MESSAGE_REPLY, Data: json.RawMessage(`{"status":true,"message":"mine"}`)})
+			},
+			reply:   `{"status":true,"message":"mine"}`,
+			outcome: OutcomeApproved,
+		},
+		{
+			name: "rejected by the user",
+			answer: func(t *testing.T, wallet *websocket.Conn, id string) {
+				writeEnvelope(t, wallet, &Envelope{ID: id, Type: MESSAGE_ERROR, Code: ERROR_CODE_USER_REJECTED, Error: "declined"})
+			},
+			outcome: OutcomeRejected,
+		},
+		{
+			name: "not approved in time by the user",
+			answer: func(t *testing.T, wallet *websocket.Conn, id string) {
+				writeEnvelope(t, wallet, &Envelope{ID: id, Type: MESSAGE_ERROR, Code: ERROR_CODE_APPROVAL_TIMEOUT})
+			},
+			outcome: OutcomeApprovalTimeout,
+		},
+		{
+			name: "transaction failed by the node",
+			answer: func(t *testing.T, wallet *websocket.Conn, id string) {
+				writeEnvelope(t, wallet, &Envelope{ID: id, Type: MESSAGE_REPLY, Data: json.RawMessage(`{"status":false,"message":"insufficient balance"}`)})
+			},
+			outcome: OutcomeWalletError,
+		},
+		{
+			name:    "no reply before the deadline",
+			timeout: 100 * time.Millisecond,
+			answer: func(t *testing.T, wallet *websocket.Conn, id string) {
+				writeEnvelope(t, wallet, &Envelope{ID: "other", Type: MESSAGE_REPLY, Data: json.RawMessage(`{"status":true}`)})
+			},
+			outcome: OutcomeApprovalTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dappConn, walletConn := newTestConn(t)
+			broker := NewBroker(dappConn)
+			defer broker.Close()
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			go func() {
+				if cmd, ok := readCommand(t, walletConn); ok {
+					tt.answer(t, walletConn, cmd.ID)
+				}
+			}()
+
+			reply, err := broker.SendContext(ctx, "TRANSFER_FT", map[string]interface{}{"ft_count": 1})
+			if outcome := OutcomeOf(err); outcome != tt.outcome {
+				t.Fatalf("SendContext() outcome = %v, want %v, err: %v", outcome, tt.outcome, err)
+			}
+			if err == nil && string(reply) != tt.reply {
+				t.Fatalf("SendContext() reply = %s, want %s", reply, tt.reply)
+			}
+		})
+	}
+}
+
+func TestBrokerSerializesCommands(t *testing.T) {
+	dappConn, walletConn := newTestConn(t)
+	broker := NewBroker(dappConn)
+	defer broker.Close()
+
+	commands := make(chan *Envelope)
+	go func() {
+		for {
+			_, msg, err := walletConn.ReadMessage()
+			if err != nil {
+				close(commands)
+				return
+			}
+			envelope, err := parseEnvelope(msg)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			commands <- envelope
+		}
+	}()
+
+	replies := make(chan string, 2)
+	for _, action := range []string{"CREATE_FT", "TRANSFER_FT"} {
+		go func() {
+			reply, err := broker.Send(action, map[string]interface{}{})
+			if err != nil {
+				t.Error(err)
+			}
+			replies <- string(reply)
+		}()
+	}
+
+	first := <-commands
+	select {
+	case second := <-commands:
+		t.Fatalf("command %v sent before %v was replied to", second.ID, first.ID)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	writeEnvelope(t, walletConn, &Envelope{ID: first.ID, Type: MESSAGE_REPLY, Data: json.RawMessage(`{"n":1}`)})
+	second := <-commands
+	if second.ID == first.ID {
+		t.Fatalf("command %v sent twice", first.ID)
+	}
+	writeEnvelope(t, walletConn, &Envelope{ID: second.ID, Type: MESSAGE_REPLY, Data: json.RawMessage(`{"n":2}`)})
+
+	got := map[string]bool{<-replies: true, <-replies: true}
+	if !got[`{"n":1}`] || !got[`{"n":2}`] {
+		t.Fatalf("replies = %v, want one reply per command", got)
+	}
+}
+
+func TestBrokerCloseFailsPendingCommands(t *testing.T) {
+	dappConn, walletConn := newTestConn(t)
+	broker := NewBroker(dappConn)
+
+	go func() {
+		readCommand(t, walletConn)
+		broker.Close()
+	}()
+
+	_, err := broker.Send("TRANSFER_FT", map[string]interface{}{})
+	if !errors.Is(err, ErrConnectionClosed) {
+		t.Fatalf("Send() error = %v, want %v", err, ErrConnectionClosed)
+	}
+	if OutcomeOf(err) != OutcomeWalletError {
+		t.Fatalf("Send() outcome = %v, want %v", OutcomeOf(err), OutcomeWalletError)
+	}
+}
@@ -0,0 +1,209 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// NODE_REQUEST_TIMEOUT bounds every request made to the Rubix node, as
+// transfers wait for the quorums to sign them
+const NODE_REQUEST_TIMEOUT = 2 * time.Minute
+
+// Modes of the DIDs managed by a Rubix node, which tell how the node asks
+// for the signature of a transaction
+const (
+	DID_MODE_BASIC    = 0
+	DID_MODE_STANDARD = 1
+	DID_MODE_WALLET   = 2
+	DID_MODE_CHILD    = 3
+	DID_MODE_LITE     = 4
+)
+
+// nodeAction is the Rubix node API carrying out an extension command.
+// signerField names the payload field holding the DID which signs the
+// command, and renames maps the payload fields to those of the API.
+type nodeAction struct {
+	path        string
+	signerField string
+	renames     map[string]string
+}
+
+var nodeActions = map[string]nodeAction{
+	"CREATE_FT":   {path: "/api/create-ft", signerField: "did"},
+	"TRANSFER_FT": {path: "/api/initiate-ft-transfer", signerField: "sender"},
+	"DEPLOY_NFT":  {path: "/api/deploy-nft", signerField: "did"},
+	"EXECUTE_NFT": {path: "/api/execute-nft", signerField: "executor", renames: map[string]string{"executor": "owner"}},
+	// The node transfers an NFT by executing it with the new owner as receiver
+	"TRANSFER_NFT": {path: "/api/execute-nft", signerField: "owner"},
+}
+
+// nodeResponse is the basic response of the Rubix node API
+type nodeResponse struct {
+	Status  bool        `json:"status"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result"`
+}
+
+// signatureRequest is the result of a Rubix node API call which needs the
+// signature of the DID to go on
+type signatureRequest struct {
+	ID   string `json:"id"`
+	Mode int    `json:"mode"`
+}
+
+type signatureResponse struct {
+	ID       string `json:"id"`
+	Mode     int    `json:"mode"`
+	Password string `json:"password"`
+}
+
+// NodeSigner carries out extension commands through the API of a Rubix node,
+// signing them with a DID whose keys are managed by the node. It serves the
+// flows which run without a wallet, and only signs for its own DID. The DID
+// must be of a mode signed with its password: basic, child or lite.
+type NodeSigner struct {
+	nodeAddress string
+	did         string
+	password    string
+	client      *http.Client
+}
+
+func NewNodeSigner(nodeAddress string, did string, password string) *NodeSigner {
+	return &NodeSigner{
+		nodeAddress: nodeAddress,
+		did:         did,
+		password:    password,
+		client:      &http.Client{Timeout: NODE_REQUEST_TIMEOUT},
+	}
+}
+
+// DID returns the DID signing the commands
+func (s *NodeSigner) DID() string {
+	return s.did
+}
+
+func (s *NodeSigner) Send(action string, payload interface{}) ([]byte, error) {
+	return s.SendContext(context.Background(), action, payload)
+}
+
+// SendContext calls the node API of the command, and answers the signature
+// requests of the node with the password of the DID. It returns the final
+// response of the node, as a wallet would relay it.
+func (s *NodeSigner) SendContext(ctx context.Context, action string, payload interface{}) ([]byte, error) {
+	nodeAction, ok := nodeActions[action]
+	if !ok {
+		return nil, fmt.Errorf("%v command cannot be carried out by the node", action)
+	}
+	if err := LatestSchema().ValidatePayload(action, payload); err != nil {
+		return nil, err
+	}
+
+	request, err := s.request(action, nodeAction, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.post(ctx, nodeAction.path, request)
+	for err == nil {
+		if !response.Status {
+			return nil, &CommandError{Action: action, Outcome: OutcomeWalletError, Reason: response.Message}
+		}
+
+		var sigRequest signatureRequest
+		resultBytes, _ := json.Marshal(response.Result)
+		if json.Unmarshal(resultBytes, &sigRequest) != nil || sigRequest.ID == "" {
+			break
+		}
+
+		switch sigRequest.Mode {
+		case DID_MODE_BASIC, DID_MODE_CHILD, DID_MODE_LITE:
+		default:
+			return nil, fmt.Errorf("%v of mode %v cannot sign %v commands with its password", s.did, sigRequest.Mode, action)
+		}
+
+		response, err = s.post(ctx, "/api/signature-response", &signatureResponse{
+			ID:       sigRequest.ID,
+			Mode:     sigRequest.Mode,
+			Password: s.password,
+		})
+	}
+	if err != nil {
+		return nil, AsCommandError(action, fmt.Errorf("%v command failed on the node, err: %w", action, err))
+	}
+
+	return json.Marshal(response)
+}
+
+// request turns the payload of a command into the request of its node API.
+// Commands signed by another DID are refused.
+func (s *NodeSigner) request(action string, nodeAction nodeAction, payload interface{}) (map[string]interface{}, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal %v payload, err: %v", action, err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payloadBytes, &fields); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %v payload, err: %v", action, err)
+	}
+
+	if signer, _ := fields[nodeAction.signerField].(string); signer != s.did {
+		return nil, fmt.Errorf("%v command must be signed by %v, not by the node signer %v", action, signer, s.did)
+	}
+
+	request := make(map[string]interface{}, len(fields)+1)
+	for name, value := range fields {
+		if renamed, ok := nodeAction.renames[name]; ok {
+			name = renamed
+		}
+		request[name] = value
+	}
+	request["password"] = s.password
+
+	return request, nil
+}
+
+func (s *NodeSigner) post(ctx context.Context, path string, body interface{}) (*nodeResponse, error) {
+	targetURL, err := url.JoinPath(s.nodeAddress, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct URL: %w", err)
+	}
+
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("POST %v failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("POST %v returned status %d: %s", path, resp.StatusCode, respBytes)
+	}
+
+	var response *nodeResponse
+	if err := json.Unmarshal(respBytes, &response); err != nil || response == nil {
+		return nil, fmt.Errorf("unable to unmarshal the response of %v: %s", path, respBytes)
+	}
+
+	return response, nil
+}
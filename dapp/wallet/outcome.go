@@ -0,0 +1,169 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/rubixchain/rubix-wasm/go-wasm-bridge/utils"
+)
+
+// Outcome is how the wallet dealt with an extension command
+type Outcome string
+
+const (
+	OutcomeApproved        Outcome = "approved"
+	OutcomeRejected        Outcome = "rejected"
+	OutcomeWalletError     Outcome = "wallet_error"
+	OutcomeApprovalTimeout Outcome = "approval_timeout"
+)
+
+// Error codes sent by the wallet in ERROR messages. Any other code is taken
+// as a wallet error.
+const (
+	ERROR_CODE_USER_REJECTED    = "USER_REJECTED"
+	ERROR_CODE_APPROVAL_TIMEOUT = "APPROVAL_TIMEOUT"
+	ERROR_CODE_WALLET_ERROR     = "WALLET_ERROR"
+)
+
+// Codes returned to the contract by the host functions sending extension
+// commands. CODE_FAILED is the code of a host function which traps.
+const (
+	CODE_OK               int32 = 0
+	CODE_FAILED           int32 = 1
+	CODE_REJECTED         int32 = 2
+	CODE_WALLET_ERROR     int32 = 3
+	CODE_APPROVAL_TIMEOUT int32 = 4
+)
+
+// CommandError is the error of an extension command which the wallet did not
+// approve
+type CommandError struct {
+	Action  string
+	Outcome Outcome
+	Reason  string
+
+	err error
+}
+
+func (e *CommandError) Error() string {
+	switch e.Outcome {
+	case OutcomeRejected:
+		return fmt.Sprintf("%v command was rejected by the user: %v", e.Action, e.Reason)
+	case OutcomeApprovalTimeout:
+		return fmt.Sprintf("%v command was not approved in time: %v", e.Action, e.Reason)
+	default:
+		return fmt.Sprintf("wallet failed %v command, err: %v", e.Action, e.Reason)
+	}
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.err
+}
+
+// Code returns the code reported to the contract for the command
+func (e *CommandError) Code() int32 {
+	switch e.Outcome {
+	case OutcomeRejected:
+		return CODE_REJECTED
+	case OutcomeApprovalTimeout:
+		return CODE_APPROVAL_TIMEOUT
+	default:
+		return CODE_WALLET_ERROR
+	}
+}
+
+func commandErrorFromEnvelope(action string, envelope *Envelope) *CommandError {
+	outcome := OutcomeWalletError
+	switch envelope.Code {
+	case ERROR_CODE_USER_REJECTED:
+		outcome = OutcomeRejected
+	case ERROR_CODE_APPROVAL_TIMEOUT:
+		outcome = OutcomeApprovalTimeout
+	}
+
+	return &CommandError{
+		Action:  action,
+		Outcome: outcome,
+		Reason:  envelope.Error,
+	}
+}
+
+// AsCommandError returns the CommandError of a command which ended with err.
+// An abandoned command is reported as an approval timeout, and any other
+// failure to reach the wallet as a wallet error.
+func AsCommandError(action string, err error) *CommandError {
+	if err == nil {
+		return nil
+	}
+
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) {
+		return commandErr
+	}
+
+	outcome := OutcomeWalletError
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		outcome = OutcomeApprovalTimeout
+	}
+
+	return &CommandError{
+		Action:  action,
+		Outcome: outcome,
+		Reason:  err.Error(),
+		err:     err,
+	}
+}
+
+// OutcomeOf returns the outcome of a command which ended with err
+func OutcomeOf(err error) Outcome {
+	if err == nil {
+		return OutcomeApproved
+	}
+	return AsCommandError("", err).Outcome
+}
+
+// HandleCommandError reports the failure of the extension command sent by
+// a host function to the contract. Failures of the command are returned as
+// their code, along with a description written to the output arguments, so
+// that the contract can tell a rejection from an error. Any other error
+// traps the contract.
+func HandleCommandError(caller *wasmtime.Caller, allocFunc *wasmtime.Func, outputArgs *utils.WasmArgInfo, err error) ([]wasmtime.Val, *wasmtime.Trap) {
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) {
+		return utils.HandleError(err.Error())
+	}
+
+	outcomeBytes, _ := json.Marshal(map[string]interface{}{
+		"outcome": commandErr.Outcome,
+		"code":    commandErr.Code(),
+		"error":   err.Error(),
+	})
+	if err := utils.UpdateDataToWASM(caller, allocFunc, string(outcomeBytes), outputArgs); err != nil {
+		fmt.Println("Failed to update data to WASM", err)
+		return utils.HandleError(err.Error())
+	}
+
+	return []wasmtime.Val{wasmtime.ValI32(commandErr.Code())}, nil
+}
+
+// TransactionIDFromMessage returns the transaction ID left at the end of the
+// message of a successful transaction by the Rubix node, and by the wallets
+// relaying its replies
+func TransactionIDFromMessage(message string) (string, error) {
+	messageElems := strings.Split(message, " ")
+	if len(messageElems) == 0 {
+		return "", fmt.Errorf("the message is likely empty")
+	}
+
+	lastElem := messageElems[len(messageElems)-1]
+
+	if lastElem == "" {
+		return "", fmt.Errorf("transaction ID is empty")
+	}
+
+	return lastElem, nil
+}
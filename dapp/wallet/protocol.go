@@ -0,0 +1,84 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// PROTOCOL_VERSION is the version of the envelope exchanged with the wallet.
+// Messages of any other version are dropped.
+const PROTOCOL_VERSION = 1
+
+const (
+	// MESSAGE_OPEN starts the session of a wallet, see Open
+	MESSAGE_OPEN = "OPEN"
+	// MESSAGE_OPEN_EXTENSION asks the wallet to approve an extension command
+	MESSAGE_OPEN_EXTENSION = "OPEN_EXTENSION"
+	// MESSAGE_REPLY carries the wallet's response to a command
+	MESSAGE_REPLY = "REPLY"
+	// MESSAGE_ERROR reports that the wallet could not process a command
+	MESSAGE_ERROR = "ERROR"
+	// MESSAGE_PROGRESS reports that the wallet is still working on a command
+	MESSAGE_PROGRESS = "PROGRESS"
+)
+
+// ERROR_CODE_INVALID_REQUEST is sent in the ERROR message answering a request
+// of the wallet which could not be served
+const ERROR_CODE_INVALID_REQUEST = "INVALID_REQUEST"
+
+// Envelope wraps every message exchanged with the wallet. Commands carry an
+// ID, which the wallet repeats in the REPLY, ERROR and PROGRESS messages
+// about them. Notifications pushed by the dapp carry no ID. ERROR messages
+// tell why the command failed with one of the ERROR_CODE values.
+type Envelope struct {
+	Version int             `json:"version"`
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Code    string          `json:"code,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// walletResponse is the response of the Rubix node relayed by the wallet in
+// REPLY messages
+type walletResponse struct {
+	Status  *bool  `json:"status"`
+	Message string `json:"message"`
+}
+
+func newMessageID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func marshalEnvelope(id string, msgType string, data interface{}) ([]byte, error) {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal %v message, err: %v", msgType, err)
+	}
+
+	return json.Marshal(&Envelope{
+		Version: PROTOCOL_VERSION,
+		ID:      id,
+		Type:    msgType,
+		Data:    dataBytes,
+	})
+}
+
+func parseEnvelope(msg []byte) (*Envelope, error) {
+	var envelope *Envelope
+	if err := json.Unmarshal(msg, &envelope); err != nil {
+		return nil, fmt.Errorf("malformed message, err: %v", err)
+	}
+	if envelope == nil {
+		return nil, fmt.Errorf("malformed message")
+	}
+	if envelope.Version != PROTOCOL_VERSION {
+		return nil, fmt.Errorf("unsupported protocol version %v, expected %v", envelope.Version, PROTOCOL_VERSION)
+	}
+
+	return envelope, nil
+}
@@ -0,0 +1,298 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Session is a single wallet connection of a DID. A DID has several sessions
+// when the dapp is open in several browser tabs.
+type Session struct {
+	ID          string    `json:"session_id"`
+	DID         string    `json:"did"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Connected   bool      `json:"connected"`
+	Active      bool      `json:"active"`
+
+	// SchemaVersion is the version of the extension commands negotiated
+	// by the wallet, zero if they are not validated
+	SchemaVersion int `json:"schema_version,omitempty"`
+
+	Broker *Broker `json:"-"`
+}
+
+type EventType string
+
+const (
+	EventConnected    EventType = "connected"
+	EventReconnected  EventType = "reconnected"
+	EventDisconnected EventType = "disconnected"
+)
+
+// Event reports a session connecting to, reconnecting to or disconnecting
+// from the dapp
+type Event struct {
+	Type    EventType
+	Session Session
+}
+
+// Registry keeps the wallet sessions connected to the dapp, by DID
+type Registry struct {
+	mu       sync.RWMutex
+	sessions map[string][]*Session
+	active   map[string]string
+
+	subscribersMu sync.RWMutex
+	subscribers   map[int]func(Event)
+	nextID        int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		sessions:    make(map[string][]*Session),
+		active:      make(map[string]string),
+		subscribers: make(map[int]func(Event)),
+	}
+}
+
+func newSessionID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Subscribe calls fn for every session connecting, reconnecting or
+// disconnecting, until the returned function is called. Events are delivered
+// synchronously, so fn must not block.
+func (r *Registry) Subscribe(fn func(Event)) (unsubscribe func()) {
+	r.subscribersMu.Lock()
+	id := r.nextID
+	r.nextID++
+	r.subscribers[id] = fn
+	r.subscribersMu.Unlock()
+
+	return func() {
+		r.subscribersMu.Lock()
+		delete(r.subscribers, id)
+		r.subscribersMu.Unlock()
+	}
+}
+
+func (r *Registry) publish(event Event) {
+	r.subscribersMu.RLock()
+	defer r.subscribersMu.RUnlock()
+
+	for _, fn := range r.subscribers {
+		fn(event)
+	}
+}
+
+// Add registers a new session of did, served by broker
+func (r *Registry) Add(did string, broker *Broker) *Session {
+	session := &Session{
+		ID:          newSessionID(),
+		DID:         did,
+		ConnectedAt: time.Now(),
+		Broker:      broker,
+	}
+
+	r.mu.Lock()
+	r.sessions[did] = append(r.sessions[did], session)
+	r.mu.Unlock()
+
+	r.publish(Event{Type: EventConnected, Session: *session})
+	return session
+}
+
+// Resume attaches conn to the session sessionID of did, which lost its
+// connection less than its reconnect grace period ago. The wallet must have
+// negotiated the schema of the session on conn.
+func (r *Registry) Resume(did string, sessionID string, conn *websocket.Conn, schema *Schema) (*Session, error) {
+	r.mu.RLock()
+	var session *Session
+	for _, s := range r.sessions[did] {
+		if s.ID == sessionID {
+			session = s
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if session == nil {
+		return nil, fmt.Errorf("session %v of %v not found", sessionID, did)
+	}
+	if err := session.Broker.Attach(conn, schema); err != nil {
+		return nil, fmt.Errorf("unable to resume session %v of %v, err: %v", sessionID, did, err)
+	}
+
+	r.publish(Event{Type: EventReconnected, Session: *session})
+	return session, nil
+}
+
+// Remove unregisters session. The DID falls back to its most recent session
+// if session was the active one.
+func (r *Registry) Remove(session *Session) {
+	r.mu.Lock()
+	sessions := r.sessions[session.DID]
+	for i, s := range sessions {
+		if s == session {
+			sessions = append(sessions[:i:i], sessions[i+1:]...)
+			break
+		}
+	}
+	if len(sessions) == 0 {
+		delete(r.sessions, session.DID)
+	} else {
+		r.sessions[session.DID] = sessions
+	}
+	if r.active[session.DID] == session.ID {
+		delete(r.active, session.DID)
+	}
+	r.mu.Unlock()
+
+	r.publish(Event{Type: EventDisconnected, Session: *session})
+}
+
+// Get returns the session of did to which wallet commands are sent: the
+// session chosen with SetActive if any, the most recent one otherwise.
+// Connected sessions are preferred to those waiting for their wallet to
+// reconnect.
+func (r *Registry) Get(did string) (*Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.currentLocked(did)
+}
+
+func (r *Registry) currentLocked(did string) (*Session, bool) {
+	sessions := r.sessions[did]
+	if len(sessions) == 0 {
+		return nil, false
+	}
+
+	var active *Session
+	if activeID, ok := r.active[did]; ok {
+		for _, session := range sessions {
+			if session.ID == activeID {
+				active = session
+				break
+			}
+		}
+	}
+	if active != nil && active.Broker.Connected() {
+		return active, true
+	}
+
+	for i := len(sessions) - 1; i >= 0; i-- {
+		if sessions[i].Broker.Connected() {
+			return sessions[i], true
+		}
+	}
+
+	if active != nil {
+		return active, true
+	}
+	return sessions[len(sessions)-1], true
+}
+
+// SetActive makes sessionID the session of did to which wallet commands
+// are sent
+func (r *Registry) SetActive(did string, sessionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, session := range r.sessions[did] {
+		if session.ID == sessionID {
+			r.active[did] = sessionID
+			return nil
+		}
+	}
+
+	return fmt.Errorf("session %v of %v not found", sessionID, did)
+}
+
+// Sessions returns a snapshot of the sessions of did, oldest first
+func (r *Registry) Sessions(did string) []Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sessions := make([]Session, 0, len(r.sessions[did]))
+	for _, session := range r.sessions[did] {
+		sessions = append(sessions, r.snapshotLocked(session))
+	}
+	return sessions
+}
+
+// All returns a snapshot of every session, by DID and oldest first
+func (r *Registry) All() []Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	dids := make([]string, 0, len(r.sessions))
+	for did := range r.sessions {
+		dids = append(dids, did)
+	}
+	sort.Strings(dids)
+
+	var sessions []Session
+	for _, did := range dids {
+		for _, session := range r.sessions[did] {
+			sessions = append(sessions, r.snapshotLocked(session))
+		}
+	}
+	return sessions
+}
+
+// Find returns the session sessionID, whatever its DID
+func (r *Registry) Find(sessionID string) (*Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, sessions := range r.sessions {
+		for _, session := range sessions {
+			if session.ID == sessionID {
+				return session, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Snapshot returns a copy of session, telling whether it is connected and
+// whether it is the session of its DID to which wallet commands are sent
+func (r *Registry) Snapshot(session *Session) Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.snapshotLocked(session)
+}
+
+func (r *Registry) snapshotLocked(session *Session) Session {
+	current, hasCurrent := r.currentLocked(session.DID)
+
+	snapshot := *session
+	snapshot.Connected = session.Broker.Connected()
+	snapshot.Active = hasCurrent && session == current
+	if schema := session.Broker.Schema(); schema != nil {
+		snapshot.SchemaVersion = schema.Version
+	}
+	return snapshot
+}
+
+// DIDs returns the DIDs with at least one session
+func (r *Registry) DIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	dids := make([]string, 0, len(r.sessions))
+	for did := range r.sessions {
+		dids = append(dids, did)
+	}
+	return dids
+}
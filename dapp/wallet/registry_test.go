@@ -0,0 +1,150 @@
+package wallet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRegistryGet(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup adds the sessions of the DID, and returns the one expected
+		// from Get, nil if none is
+		setup func(t *testing.T, r *Registry) *Session
+	}{
+		{
+			name: "unknown DID",
+			setup: func(t *testing.T, r *Registry) *Session {
+				return nil
+			},
+		},
+		{
+			name: "most recent session",
+			setup: func(t *testing.T, r *Registry) *Session {
+				r.Add("did", newTestBroker(t))
+				return r.Add("did", newTestBroker(t))
+			},
+		},
+		{
+			name: "session chosen with SetActive",
+			setup: func(t *testing.T, r *Registry) *Session {
+				first := r.Add("did", newTestBroker(t))
+				r.Add("did", newTestBroker(t))
+				if err := r.SetActive("did", first.ID); err != nil {
+					t.Fatal(err)
+				}
+				return first
+			},
+		},
+		{
+			name: "connected session preferred to the active one",
+			setup: func(t *testing.T, r *Registry) *Session {
+				first := r.Add("did", newTestBroker(t))
+				second := r.Add("did", newTestBroker(t))
+				if err := r.SetActive("did", second.ID); err != nil {
+					t.Fatal(err)
+				}
+				second.Broker.Close()
+				return first
+			},
+		},
+		{
+			name: "falls back once the active session is removed",
+			setup: func(t *testing.T, r *Registry) *Session {
+				first := r.Add("did", newTestBroker(t))
+				second := r.Add("did", newTestBroker(t))
+				third := r.Add("did", newTestBroker(t))
+				if err := r.SetActive("did", first.ID); err != nil {
+					t.Fatal(err)
+				}
+				r.Remove(first)
+				r.Remove(third)
+				return second
+			},
+		},
+		{
+			name: "sessions of other DIDs are not returned",
+			setup: func(t *testing.T, r *Registry) *Session {
+				r.Add("other", newTestBroker(t))
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			want := tt.setup(t, r)
+
+			got, ok := r.Get("did")
+			if want == nil {
+				if ok {
+					t.Fatalf("Get() = session %v, want none", got.ID)
+				}
+				return
+			}
+			if !ok || got != want {
+				t.Fatalf("Get() = %v (%v), want session %v", got, ok, want.ID)
+			}
+		})
+	}
+}
+
+func TestRegistryFind(t *testing.T) {
+	r := NewRegistry()
+	session := r.Add("did", newTestBroker(t))
+	r.Add("other", newTestBroker(t))
+
+	if found, ok := r.Find(session.ID); !ok || found != session {
+		t.Fatalf("Find(%v) = %v (%v), want the session", session.ID, found, ok)
+	}
+	if _, ok := r.Find("unknown"); ok {
+		t.Fatal("Find() found an unknown session")
+	}
+	if err := r.SetActive("other", session.ID); err == nil {
+		t.Fatal("SetActive() accepted the session of another DID")
+	}
+
+	r.Remove(session)
+	if _, ok := r.Find(session.ID); ok {
+		t.Fatal("Find() found a removed session")
+	}
+}
+
+func newTestBroker(t *testing.T) *Broker {
+	t.Helper()
+
+	dappConn, _ := newTestConn(t)
+	broker := NewBroker(dappConn)
+	t.Cleanup(broker.Close)
+	return broker
+}
+
+// newTestConn returns both ends of a WebSocket connection: the one of the
+// dapp, taken over by a broker, and the one of the wallet
+func newTestConn(t *testing.T) (dapp *websocket.Conn, wallet *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	wallet, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wallet.Close() })
+
+	return <-conns, wallet
+}
@@ -0,0 +1,444 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MESSAGE_OPEN_ACK accepts the OPEN message of a wallet, telling it the
+// schema version negotiated for its session
+const MESSAGE_OPEN_ACK = "OPEN_ACK"
+
+// ERROR_CODE_UNSUPPORTED_SCHEMA is sent in the ERROR message which rejects a
+// wallet whose OPEN message matches none of the schemas of the dapp
+const ERROR_CODE_UNSUPPORTED_SCHEMA = "UNSUPPORTED_SCHEMA"
+
+// OPEN_TIMEOUT is how long the wallet has to send its OPEN message
+const OPEN_TIMEOUT = 30 * time.Second
+
+// The kinds of JSON values of the fields of a message
+type FieldKind string
+
+const (
+	KindString  FieldKind = "string"
+	KindNumber  FieldKind = "number"
+	KindBoolean FieldKind = "boolean"
+	KindAny     FieldKind = "any"
+)
+
+type Field struct {
+	Name     string
+	Kind     FieldKind
+	Optional bool
+}
+
+// MessageSchema lists the fields of a JSON object. Fields which are not
+// listed are rejected from a closed schema, and ignored otherwise.
+type MessageSchema struct {
+	Fields []Field
+	Closed bool
+}
+
+// Validate checks that data is a JSON object matching the schema
+func (m *MessageSchema) Validate(data []byte) error {
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil || object == nil {
+		return fmt.Errorf("expected a JSON object")
+	}
+
+	known := make(map[string]bool, len(m.Fields))
+	for _, field := range m.Fields {
+		known[field.Name] = true
+
+		value, ok := object[field.Name]
+		if !ok {
+			if field.Optional {
+				continue
+			}
+			return fmt.Errorf("missing field %q", field.Name)
+		}
+		if value == nil && field.Optional {
+			continue
+		}
+		if !field.Kind.matches(value) {
+			return fmt.Errorf("field %q is not a %v", field.Name, field.Kind)
+		}
+	}
+
+	if m.Closed {
+		for name := range object {
+			if !known[name] {
+				return fmt.Errorf("unknown field %q", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (k FieldKind) matches(value interface{}) bool {
+	switch k {
+	case KindString:
+		_, ok := value.(string)
+		return ok
+	case KindNumber:
+		_, ok := value.(float64)
+		return ok
+	case KindBoolean:
+		_, ok := value.(bool)
+		return ok
+	default:
+		return true
+	}
+}
+
+func (m *MessageSchema) names(required bool) []string {
+	names := make([]string, 0, len(m.Fields))
+	for _, field := range m.Fields {
+		if !required || !field.Optional {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+// ActionSchema describes the payload of an extension command sent to the
+// wallet, and the data of the REPLY to it
+type ActionSchema struct {
+	Payload MessageSchema
+	Reply   MessageSchema
+}
+
+// Schema is a version of the extension commands understood by the dapp
+type Schema struct {
+	Version int
+	Actions map[string]*ActionSchema
+}
+
+// ValidatePayload checks the payload of an action before it is sent
+func (s *Schema) ValidatePayload(action string, payload interface{}) error {
+	actionSchema, ok := s.Actions[action]
+	if !ok {
+		return fmt.Errorf("action %v is not part of schema v%v", action, s.Version)
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to marshal %v payload, err: %v", action, err)
+	}
+	if err := actionSchema.Payload.Validate(payloadBytes); err != nil {
+		return fmt.Errorf("%v payload does not match schema v%v: %v", action, s.Version, err)
+	}
+
+	return nil
+}
+
+// ValidateReply checks the data of the wallet's REPLY to an action
+func (s *Schema) ValidateReply(action string, data []byte) error {
+	actionSchema, ok := s.Actions[action]
+	if !ok {
+		return fmt.Errorf("action %v is not part of schema v%v", action, s.Version)
+	}
+
+	if err := actionSchema.Reply.Validate(data); err != nil {
+		return fmt.Errorf("reply to %v does not match schema v%v: %v", action, s.Version, err)
+	}
+
+	return nil
+}
+
+// nodeReply is the response of the Rubix node, which the wallet relays in
+// the REPLY to every command
+var nodeReply = MessageSchema{
+	Fields: []Field{
+		{Name: "status", Kind: KindBoolean},
+		{Name: "message", Kind: KindString},
+		{Name: "result", Kind: KindAny, Optional: true},
+		{Name: "tx_id", Kind: KindString, Optional: true},
+	},
+}
+
+var schemaV1 = &Schema{
+	Version: 1,
+	Actions: map[string]*ActionSchema{
+		"CREATE_FT": {
+			Payload: MessageSchema{
+				Fields: []Field{
+					{Name: "did", Kind: KindString},
+					{Name: "ft_count", Kind: KindNumber},
+					{Name: "ft_name", Kind: KindString},
+					{Name: "token_count", Kind: KindNumber},
+					{Name: "quorum_type", Kind: KindNumber},
+				},
+				Closed: true,
+			},
+			Reply: nodeReply,
+		},
+		"TRANSFER_FT": {
+			Payload: MessageSchema{
+				Fields: []Field{
+					{Name: "ft_count", Kind: KindNumber},
+					{Name: "ft_name", Kind: KindString},
+					{Name: "creatorDID", Kind: KindString},
+					{Name: "quorum_type", Kind: KindNumber},
+					{Name: "comment", Kind: KindString},
+					{Name: "receiver", Kind: KindString},
+					{Name: "sender", Kind: KindString},
+				},
+				Closed: true,
+			},
+			Reply: nodeReply,
+		},
+		"DEPLOY_NFT": {
+			Payload: MessageSchema{
+				Fields: []Field{
+					{Name: "nft", Kind: KindString},
+					{Name: "did", Kind: KindString},
+					{Name: "quorum_type", Kind: KindNumber},
+					{Name: "nft_data", Kind: KindString},
+					{Name: "nft_value", Kind: KindNumber},
+					{Name: "nft_metadata", Kind: KindString},
+					{Name: "nft_file_name", Kind: KindString},
+				},
+				Closed: true,
+			},
+			Reply: nodeReply,
+		},
+		"EXECUTE_NFT": {
+			Payload: MessageSchema{
+				Fields: []Field{
+					{Name: "nft", Kind: KindString},
+					{Name: "executor", Kind: KindString},
+					{Name: "receiver", Kind: KindString},
+					{Name: "comment", Kind: KindString},
+					{Name: "nft_value", Kind: KindNumber},
+					{Name: "nft_data", Kind: KindString},
+					{Name: "quorum_type", Kind: KindNumber},
+				},
+				Closed: true,
+			},
+			Reply: nodeReply,
+		},
+	},
+}
+
+// schemaV2 adds the transfer of an NFT to a new owner
+var schemaV2 = schemaV1.extend(2, map[string]*ActionSchema{
+	"TRANSFER_NFT": {
+		Payload: MessageSchema{
+			Fields: []Field{
+				{Name: "nft", Kind: KindString},
+				{Name: "owner", Kind: KindString},
+				{Name: "receiver", Kind: KindString},
+				{Name: "comment", Kind: KindString},
+				{Name: "nft_value", Kind: KindNumber},
+				{Name: "nft_data", Kind: KindString},
+				{Name: "quorum_type", Kind: KindNumber},
+			},
+			Closed: true,
+		},
+		Reply: nodeReply,
+	},
+})
+
+// schemas are the versions of the extension commands supported by the dapp,
+// the latest last
+var schemas = []*Schema{schemaV1, schemaV2}
+
+// extend returns the next version of the schema, with the given actions
+// added to, or replacing, those of s
+func (s *Schema) extend(version int, actions map[string]*ActionSchema) *Schema {
+	extended := &Schema{
+		Version: version,
+		Actions: make(map[string]*ActionSchema, len(s.Actions)+len(actions)),
+	}
+	for name, action := range s.Actions {
+		extended.Actions[name] = action
+	}
+	for name, action := range actions {
+		extended.Actions[name] = action
+	}
+	return extended
+}
+
+// LatestSchema returns the latest version of the extension commands
+func LatestSchema() *Schema {
+	return schemas[len(schemas)-1]
+}
+
+// ActionFields lists the fields of an action as declared by the wallet
+type ActionFields struct {
+	Payload []string `json:"payload"`
+	Reply   []string `json:"reply"`
+}
+
+// Open is the data of the OPEN message with which a wallet starts its
+// session. It lists the schema versions the wallet supports, and the fields
+// it reads and writes for every action.
+type Open struct {
+	SchemaVersions []int                    `json:"schema_versions"`
+	Actions        map[string]*ActionFields `json:"actions"`
+}
+
+// OpenAck is the data of the OPEN_ACK message
+type OpenAck struct {
+	SchemaVersion int `json:"schema_version"`
+}
+
+// Negotiate picks the latest schema version supported by both the dapp and
+// the wallet which sent the OPEN message msg, and matched by the wallet: it
+// must declare every action of that version, with the same payload fields,
+// and must reply with every required field.
+func Negotiate(msg []byte) (*Schema, error) {
+	envelope, err := parseEnvelope(msg)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.Type != MESSAGE_OPEN {
+		return nil, fmt.Errorf("expected an %v message, got %v", MESSAGE_OPEN, envelope.Type)
+	}
+
+	var open Open
+	if err := json.Unmarshal(envelope.Data, &open); err != nil {
+		return nil, fmt.Errorf("malformed %v message, err: %v", MESSAGE_OPEN, err)
+	}
+
+	supported := make(map[int]bool, len(open.SchemaVersions))
+	for _, version := range open.SchemaVersions {
+		supported[version] = true
+	}
+
+	// An older version is used when the wallet does not match the latest
+	// one, the mismatch of the latest version is reported otherwise
+	var mismatch error
+	for i := len(schemas) - 1; i >= 0; i-- {
+		schema := schemas[i]
+		if !supported[schema.Version] {
+			continue
+		}
+		if err := schema.check(open.Actions); err != nil {
+			if mismatch == nil {
+				mismatch = err
+			}
+			continue
+		}
+		return schema, nil
+	}
+	if mismatch != nil {
+		return nil, mismatch
+	}
+
+	return nil, fmt.Errorf("none of the schema versions %v of the wallet is supported, the dapp supports %v", open.SchemaVersions, schemaVersions())
+}
+
+// Handshake reads the OPEN message of the wallet connected over conn and
+// answers it with an OPEN_ACK carrying the negotiated schema version, or
+// with an ERROR when the wallet matches none of the schemas, in which case
+// it is up to the caller to close conn
+func Handshake(conn *websocket.Conn) (*Schema, error) {
+	conn.SetReadDeadline(time.Now().Add(OPEN_TIMEOUT))
+	_, msg, err := conn.ReadMessage()
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("no %v message received, err: %v", MESSAGE_OPEN, err)
+	}
+
+	schema, err := Negotiate(msg)
+	if err != nil {
+		rejectBytes, _ := json.Marshal(&Envelope{
+			Version: PROTOCOL_VERSION,
+			Type:    MESSAGE_ERROR,
+			Code:    ERROR_CODE_UNSUPPORTED_SCHEMA,
+			Error:   err.Error(),
+		})
+		conn.WriteMessage(websocket.TextMessage, rejectBytes)
+		return nil, err
+	}
+
+	ackBytes, err := marshalEnvelope("", MESSAGE_OPEN_ACK, &OpenAck{SchemaVersion: schema.Version})
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, ackBytes); err != nil {
+		return nil, fmt.Errorf("unable to send %v message, err: %v", MESSAGE_OPEN_ACK, err)
+	}
+
+	return schema, nil
+}
+
+// check compares the fields declared by the wallet for every action with
+// those of the schema
+func (s *Schema) check(declared map[string]*ActionFields) error {
+	var mismatches []string
+	for _, action := range s.actionNames() {
+		actionSchema := s.Actions[action]
+
+		fields, ok := declared[action]
+		if !ok || fields == nil {
+			mismatches = append(mismatches, fmt.Sprintf("%v is not supported", action))
+			continue
+		}
+
+		missing, unknown := diffFields(actionSchema.Payload.names(false), fields.Payload)
+		if len(missing) > 0 {
+			mismatches = append(mismatches, fmt.Sprintf("%v payload lacks %v", action, strings.Join(missing, ", ")))
+		}
+		if len(unknown) > 0 {
+			mismatches = append(mismatches, fmt.Sprintf("%v payload has unknown %v", action, strings.Join(unknown, ", ")))
+		}
+
+		missing, _ = diffFields(actionSchema.Reply.names(true), fields.Reply)
+		if len(missing) > 0 {
+			mismatches = append(mismatches, fmt.Sprintf("%v reply lacks %v", action, strings.Join(missing, ", ")))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("wallet does not match schema v%v: %v", s.Version, strings.Join(mismatches, "; "))
+	}
+	return nil
+}
+
+func (s *Schema) actionNames() []string {
+	names := make([]string, 0, len(s.Actions))
+	for name := range s.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// diffFields returns the expected fields missing from actual, and the
+// fields of actual which are not expected
+func diffFields(expected []string, actual []string) (missing []string, unknown []string) {
+	actualSet := make(map[string]bool, len(actual))
+	for _, name := range actual {
+		actualSet[name] = true
+	}
+	expectedSet := make(map[string]bool, len(expected))
+	for _, name := range expected {
+		expectedSet[name] = true
+		if !actualSet[name] {
+			missing = append(missing, name)
+		}
+	}
+	for _, name := range actual {
+		if !expectedSet[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	return missing, unknown
+}
+
+func schemaVersions() []int {
+	versions := make([]int, 0, len(schemas))
+	for _, schema := range schemas {
+		versions = append(versions, schema.Version)
+	}
+	return versions
+}
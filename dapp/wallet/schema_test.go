@@ -0,0 +1,107 @@
+package wallet
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// declare lists the fields of every action of schema, as a wallet matching
+// it declares them in its OPEN message
+func declare(schema *Schema) map[string]*ActionFields {
+	actions := make(map[string]*ActionFields, len(schema.Actions))
+	for name, action := range schema.Actions {
+		actions[name] = &ActionFields{
+			Payload: action.Payload.names(false),
+			Reply:   action.Reply.names(true),
+		}
+	}
+	return actions
+}
+
+func openMessage(t *testing.T, msgType string, data interface{}) []byte {
+	t.Helper()
+
+	msg, err := marshalEnvelope("", msgType, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestNegotiate(t *testing.T) {
+	// A wallet omitting a payload field of the first action with payload
+	lacking := declare(schemaV1)
+	for _, name := range schemaV1.actionNames() {
+		if fields := lacking[name]; len(fields.Payload) > 0 {
+			lacking[name] = &ActionFields{Payload: fields.Payload[1:], Reply: fields.Reply}
+			break
+		}
+	}
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		version int
+		err     string
+	}{
+		{
+			name:    "latest version supported by both",
+			msg:     openMessage(t, MESSAGE_OPEN, &Open{SchemaVersions: []int{1, 2}, Actions: declare(schemaV2)}),
+			version: 2,
+		},
+		{
+			name:    "older version only",
+			msg:     openMessage(t, MESSAGE_OPEN, &Open{SchemaVersions: []int{1}, Actions: declare(schemaV2)}),
+			version: 1,
+		},
+		{
+			name:    "falls back when the latest version is not matched",
+			msg:     openMessage(t, MESSAGE_OPEN, &Open{SchemaVersions: []int{1, 2}, Actions: declare(schemaV1)}),
+			version: 1,
+		},
+		{
+			name: "mismatch of the only version supported",
+			msg:  openMessage(t, MESSAGE_OPEN, &Open{SchemaVersions: []int{2}, Actions: declare(schemaV1)}),
+			err:  "wallet does not match schema v2",
+		},
+		{
+			name: "payload field missing",
+			msg:  openMessage(t, MESSAGE_OPEN, &Open{SchemaVersions: []int{1}, Actions: lacking}),
+			err:  "payload lacks",
+		},
+		{
+			name: "no version in common",
+			msg:  openMessage(t, MESSAGE_OPEN, &Open{SchemaVersions: []int{99}, Actions: declare(schemaV2)}),
+			err:  "none of the schema versions [99]",
+		},
+		{
+			name: "not an OPEN message",
+			msg:  openMessage(t, MESSAGE_REPLY, &Open{SchemaVersions: []int{1}, Actions: declare(schemaV1)}),
+			err:  "expected an OPEN message",
+		},
+		{
+			name: "malformed OPEN message",
+			msg:  openMessage(t, MESSAGE_OPEN, json.RawMessage(`{"schema_versions": "1"}`)),
+			err:  "malformed OPEN message",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Negotiate(tt.msg)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Negotiate() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Negotiate() error = %v", err)
+			}
+			if schema.Version != tt.version {
+				t.Fatalf("Negotiate() version = %d, want %d", schema.Version, tt.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+// Package wallet implements the communication with the Xell wallet extension
+// connected over the /ws socket
+package wallet
+
+import "context"
+
+type ExtensionCommand struct {
+	Action  string      `json:"action"`  // Specific action to perform (e.g., "sign", "connect", "getAccounts")
+	Payload interface{} `json:"payload"` // Data needed by the extension to execute the command
+}
+
+// Signer sends an extension command to a wallet for approval and returns
+// the raw reply of the wallet
+type Signer interface {
+	Send(action string, payload interface{}) ([]byte, error)
+}
+
+// ContextSigner is a Signer whose commands can be abandoned, for instance
+// when the execution waiting on the wallet reaches its deadline
+type ContextSigner interface {
+	Signer
+	SendContext(ctx context.Context, action string, payload interface{}) ([]byte, error)
+}
@@ -0,0 +1,34 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SIMULATED_TX_ID is reported as the transaction ID of every simulated command
+const SIMULATED_TX_ID = "SIMULATED"
+
+// SimulatedSigner approves every extension command without reaching a wallet.
+// It is used for dry runs of contracts, where the commands are only recorded.
+type SimulatedSigner struct{}
+
+func NewSimulatedSigner() *SimulatedSigner {
+	return &SimulatedSigner{}
+}
+
+// Send replies with a successful response in the format of the wallet. The
+// transaction ID is also the last word of the message, as with the Rubix node.
+// The payload is validated against the latest schema, as it would be by a
+// wallet session.
+func (s *SimulatedSigner) Send(action string, payload interface{}) ([]byte, error) {
+	if err := LatestSchema().ValidatePayload(action, payload); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"status":  true,
+		"message": fmt.Sprintf("Simulated %v, transaction id %v", action, SIMULATED_TX_ID),
+		"result":  nil,
+		"tx_id":   SIMULATED_TX_ID,
+	})
+}
@@ -0,0 +1,259 @@
+// Package wasmcache compiles contract artifacts once and hands out fresh
+// instances of them for every contract call
+package wasmcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+)
+
+// EPOCH_TICK is the interval at which the engine epoch is incremented, which
+// is the precision of the contract deadlines
+const EPOCH_TICK = 10 * time.Millisecond
+
+type compiledModule struct {
+	module  *wasmtime.Module
+	wasm    []byte
+	digest  string
+	modTime time.Time
+	size    int64
+
+	// limited holds the builds of the artifact with its memories and tables
+	// capped, compiled on first use
+	limited map[Limits]*wasmtime.Module
+}
+
+// Cache keeps the compiled wasm modules of an artifacts directory. All modules
+// share a single engine, so an instance can be created from a cached module
+// without recompiling it. The engine meters the fuel consumed by contracts and
+// interrupts them at their deadline.
+type Cache struct {
+	dir    string
+	engine *wasmtime.Engine
+
+	mu      sync.RWMutex
+	modules map[string]*compiledModule
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// New compiles every wasm artifact present in dir
+func New(dir string) (*Cache, error) {
+	config := wasmtime.NewConfig()
+	config.SetConsumeFuel(true)
+	config.SetEpochInterruption(true)
+
+	c := &Cache{
+		dir:     dir,
+		engine:  wasmtime.NewEngineWithConfig(config),
+		modules: make(map[string]*compiledModule),
+		stop:    make(chan struct{}),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifacts directory %v, err: %v", dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".wasm") {
+			continue
+		}
+
+		if err := c.compile(entry.Name()); err != nil {
+			return nil, err
+		}
+	}
+
+	go c.tickEpochs()
+
+	return c, nil
+}
+
+// Close stops the epoch ticker of the cache. Contracts running past Close are
+// no longer interrupted at their deadline.
+func (c *Cache) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *Cache) compile(name string) error {
+	artifactPath := path.Join(c.dir, name)
+
+	info, err := os.Stat(artifactPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat artifact %v, err: %v", name, err)
+	}
+
+	wasmBytes, err := os.ReadFile(artifactPath)
+	if err != nil {
+		return fmt.Errorf("failed to read artifact %v, err: %v", name, err)
+	}
+
+	module, err := wasmtime.NewModule(c.engine, wasmBytes)
+	if err != nil {
+		return fmt.Errorf("failed to compile artifact %v, err: %v", name, err)
+	}
+
+	c.mu.Lock()
+	digest := sha256.Sum256(wasmBytes)
+	c.modules[name] = &compiledModule{
+		module:  module,
+		wasm:    wasmBytes,
+		digest:  hex.EncodeToString(digest[:]),
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+	c.mu.Unlock()
+
+	fmt.Printf("compiled contract artifact %v\n", name)
+	return nil
+}
+
+// lookup returns the compiled artifact name, compiling it if it was added
+// after the cache was built
+func (c *Cache) lookup(name string) (*compiledModule, error) {
+	c.mu.RLock()
+	compiled, ok := c.modules[name]
+	c.mu.RUnlock()
+	if ok {
+		return compiled, nil
+	}
+
+	if err := c.compile(name); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.modules[name], nil
+}
+
+// module returns the compiled artifact name, built with limits unless they
+// are all zero
+func (c *Cache) module(name string, limits Limits) (*wasmtime.Module, error) {
+	compiled, err := c.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if limits == (Limits{}) {
+		return compiled.module, nil
+	}
+
+	c.mu.RLock()
+	module, ok := compiled.limited[limits]
+	c.mu.RUnlock()
+	if ok {
+		return module, nil
+	}
+
+	limitedBytes, err := applyLimits(compiled.wasm, limits)
+	if err != nil {
+		return nil, fmt.Errorf("unable to limit artifact %v, err: %w", name, err)
+	}
+	module, err = wasmtime.NewModule(c.engine, limitedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile limited artifact %v, err: %v", name, err)
+	}
+
+	c.mu.Lock()
+	if compiled.limited == nil {
+		compiled.limited = make(map[Limits]*wasmtime.Module)
+	}
+	compiled.limited[limits] = module
+	c.mu.Unlock()
+
+	return module, nil
+}
+
+// Digest returns the hex encoded sha256 digest of the build of the artifact
+// name currently served by the cache
+func (c *Cache) Digest(name string) (string, error) {
+	compiled, err := c.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return compiled.digest, nil
+}
+
+func (c *Cache) tickEpochs() {
+	ticker := time.NewTicker(EPOCH_TICK)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.engine.IncrementEpoch()
+		}
+	}
+}
+
+// Watch polls the artifacts directory every interval and recompiles the
+// artifacts which were added or modified, until stop is closed. An artifact
+// which fails to compile keeps being served from its previous build.
+func (c *Cache) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.reload()
+		}
+	}
+}
+
+func (c *Cache) reload() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		fmt.Printf("unable to read artifacts directory %v, err: %v\n", c.dir, err)
+		return
+	}
+
+	present := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".wasm") {
+			continue
+		}
+		present[entry.Name()] = true
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		c.mu.RLock()
+		compiled, ok := c.modules[entry.Name()]
+		c.mu.RUnlock()
+		if ok && compiled.modTime.Equal(info.ModTime()) && compiled.size == info.Size() {
+			continue
+		}
+
+		if err := c.compile(entry.Name()); err != nil {
+			fmt.Printf("unable to reload contract artifact, err: %v\n", err)
+		}
+	}
+
+	c.mu.Lock()
+	for name := range c.modules {
+		if !present[name] {
+			delete(c.modules, name)
+			fmt.Printf("contract artifact %v removed from cache\n", name)
+		}
+	}
+	c.mu.Unlock()
+}
@@ -0,0 +1,23 @@
+package wasmcache
+
+import (
+	"path"
+	"runtime"
+	"testing"
+)
+
+func TestNewFailsWithoutTicking(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for n := 0; n < 10; n++ {
+		if _, err := New(path.Join(t.TempDir(), "missing")); err == nil {
+			t.Fatal("New() of a missing directory succeeded")
+		}
+	}
+	if after := runtime.NumGoroutine(); after >= before+10 {
+		t.Fatalf("%d goroutines left running by failed calls of New()", after-before)
+	}
+
+	cache := newTestCache(t, nil)
+	cache.Close()
+	cache.Close()
+}
@@ -0,0 +1,490 @@
+package wasmcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// GROW_FAILURE_EXPORT is the global exported by the limited builds of the
+// artifacts, which holds the number of pages requested by the last
+// memory.grow refused by the engine, or 0 while none was refused
+const GROW_FAILURE_EXPORT = "__dapp_memory_grow_failure"
+
+const (
+	sectionCustom   = 0
+	sectionType     = 1
+	sectionImport   = 2
+	sectionFunction = 3
+	sectionGlobal   = 6
+	sectionExport   = 7
+	sectionCode     = 10
+)
+
+// sectionOrder is the position of every known section in a module, custom
+// sections aside
+var sectionOrder = map[byte]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 13: 6, 6: 7, 7: 8, 8: 9, 9: 10, 12: 11, 10: 12, 11: 13}
+
+const (
+	opBlock       = 0x02
+	opLoop        = 0x03
+	opIf          = 0x04
+	opEnd         = 0x0b
+	opCall        = 0x10
+	opLocalGet    = 0x20
+	opLocalTee    = 0x22
+	opGlobalSet   = 0x24
+	opMemoryGrow  = 0x40
+	opI32Const    = 0x41
+	opI32Eq       = 0x46
+	opPrefixMisc  = 0xfc
+	opPrefixSIMD  = 0xfd
+	blockTypeNone = 0x40
+	valueTypeI32  = 0x7f
+	funcTypeForm  = 0x60
+	exportGlobal  = 0x03
+)
+
+type section struct {
+	id      byte
+	payload []byte
+}
+
+func readSections(wasmBytes []byte) ([]section, error) {
+	if len(wasmBytes) < 8 || !bytes.Equal(wasmBytes[:4], []byte("\x00asm")) {
+		return nil, errors.New("not a wasm module")
+	}
+
+	var sections []section
+	r := bytes.NewReader(wasmBytes[8:])
+	for r.Len() > 0 {
+		id, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("malformed size of section %d: %v", id, err)
+		}
+		if size > uint64(r.Len()) {
+			return nil, fmt.Errorf("section %d exceeds the module", id)
+		}
+		payload := make([]byte, size)
+		r.Read(payload)
+
+		sections = append(sections, section{id: id, payload: payload})
+	}
+
+	return sections, nil
+}
+
+func writeSections(header []byte, sections []section) []byte {
+	out := bytes.NewBuffer(append([]byte(nil), header...))
+	for _, s := range sections {
+		out.WriteByte(s.id)
+		out.Write(binary.AppendUvarint(nil, uint64(len(s.payload))))
+		out.Write(s.payload)
+	}
+	return out.Bytes()
+}
+
+// trackMemoryGrow routes every memory.grow of the module through a function
+// added to it, which records the number of pages of a refused growth in the
+// global GROW_FAILURE_EXPORT. A contract failing to grow its memory usually
+// aborts, so the trap alone does not tell that the memory limit was hit.
+func trackMemoryGrow(sections []section) ([]section, error) {
+	var types, functions, globals uint64
+	for _, s := range sections {
+		switch s.id {
+		case sectionType:
+			count, _, err := readCount(s.payload)
+			if err != nil {
+				return nil, err
+			}
+			types += count
+		case sectionImport:
+			importedFunctions, importedGlobals, err := countImports(s.payload)
+			if err != nil {
+				return nil, err
+			}
+			functions += importedFunctions
+			globals += importedGlobals
+		case sectionFunction, sectionGlobal:
+			count, _, err := readCount(s.payload)
+			if err != nil {
+				return nil, err
+			}
+			if s.id == sectionFunction {
+				functions += count
+			} else {
+				globals += count
+			}
+		}
+	}
+
+	// The function and the global are added after the existing ones, so that
+	// no index of the module changes
+	growFunction, growGlobal := functions, globals
+
+	found := false
+	for n, s := range sections {
+		if s.id != sectionCode {
+			continue
+		}
+		payload, replaced, err := rewriteCode(s.payload, growFunction)
+		if err != nil {
+			return nil, err
+		}
+		if replaced == 0 {
+			return sections, nil
+		}
+		sections[n].payload = payload
+		found = true
+	}
+	if !found {
+		return sections, nil
+	}
+
+	funcType := []byte{funcTypeForm, 1, valueTypeI32, 1, valueTypeI32}
+
+	global := []byte{valueTypeI32, 1, opI32Const, 0, opEnd}
+
+	export := binary.AppendUvarint(nil, uint64(len(GROW_FAILURE_EXPORT)))
+	export = append(export, GROW_FAILURE_EXPORT...)
+	export = append(export, exportGlobal)
+	export = binary.AppendUvarint(export, growGlobal)
+
+	// (func (param $pages i32) (result i32) (local $previous i32)
+	//   (local.set $previous (memory.grow (local.get $pages)))
+	//   (if (i32.eq (local.get $previous) (i32.const -1))
+	//     (then (global.set $failure (local.get $pages))))
+	//   (local.get $previous))
+	body := []byte{
+		1, 1, valueTypeI32,
+		opLocalGet, 0,
+		opMemoryGrow, 0,
+		opLocalTee, 1,
+		opI32Const, 0x7f,
+		opI32Eq,
+		opIf, blockTypeNone,
+		opLocalGet, 0,
+		opGlobalSet,
+	}
+	body = binary.AppendUvarint(body, growGlobal)
+	body = append(body, opEnd, opLocalGet, 1, opEnd)
+	code := append(binary.AppendUvarint(nil, uint64(len(body))), body...)
+
+	var err error
+	for _, entry := range []struct {
+		id    byte
+		entry []byte
+	}{
+		{sectionType, funcType},
+		{sectionFunction, binary.AppendUvarint(nil, types)},
+		{sectionGlobal, global},
+		{sectionExport, export},
+		{sectionCode, code},
+	} {
+		sections, err = appendEntry(sections, entry.id, entry.entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sections, nil
+}
+
+// appendEntry adds entry at the end of the section id, which is created if
+// the module has none
+func appendEntry(sections []section, id byte, entry []byte) ([]section, error) {
+	for n, s := range sections {
+		if s.id != id {
+			continue
+		}
+		count, rest, err := readCount(s.payload)
+		if err != nil {
+			return nil, err
+		}
+		payload := binary.AppendUvarint(nil, count+1)
+		payload = append(payload, rest...)
+		sections[n].payload = append(payload, entry...)
+		return sections, nil
+	}
+
+	created := section{id: id, payload: append([]byte{1}, entry...)}
+	at := len(sections)
+	for n, s := range sections {
+		if s.id != sectionCustom && sectionOrder[s.id] > sectionOrder[id] {
+			at = n
+			break
+		}
+	}
+	return append(sections[:at], append([]section{created}, sections[at:]...)...), nil
+}
+
+func readCount(payload []byte) (uint64, []byte, error) {
+	count, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return 0, nil, errors.New("malformed section: invalid entry count")
+	}
+	return count, payload[n:], nil
+}
+
+// countImports returns the number of functions and globals imported by the
+// import section payload
+func countImports(payload []byte) (functions uint64, globals uint64, err error) {
+	r := &codeReader{b: payload}
+	count, err := r.uvarint()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for n := uint64(0); n < count; n++ {
+		for name := 0; name < 2; name++ {
+			size, err := r.uvarint()
+			if err != nil {
+				return 0, 0, err
+			}
+			if err := r.skip(int(size)); err != nil {
+				return 0, 0, err
+			}
+		}
+
+		kind, err := r.byte()
+		if err != nil {
+			return 0, 0, err
+		}
+		switch kind {
+		case 0x00:
+			functions++
+			err = r.skipLEB(1)
+		case 0x01:
+			if err = r.skip(1); err == nil {
+				err = r.skipLimits()
+			}
+		case 0x02:
+			err = r.skipLimits()
+		case 0x03:
+			globals++
+			err = r.skip(2)
+		default:
+			err = fmt.Errorf("unsupported import kind %#x", kind)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return functions, globals, nil
+}
+
+// rewriteCode replaces every memory.grow of the function bodies of the code
+// section payload with a call of growFunction, and returns the number of
+// instructions replaced
+func rewriteCode(payload []byte, growFunction uint64) ([]byte, int, error) {
+	count, rest, err := readCount(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out := binary.AppendUvarint(nil, count)
+	replaced := 0
+	r := &codeReader{b: rest}
+	for n := uint64(0); n < count; n++ {
+		size, err := r.uvarint()
+		if err != nil {
+			return nil, 0, err
+		}
+		start := r.pos
+		if err := r.skip(int(size)); err != nil {
+			return nil, 0, err
+		}
+
+		body, m, err := rewriteBody(rest[start:r.pos], growFunction)
+		if err != nil {
+			return nil, 0, fmt.Errorf("function %d: %v", n, err)
+		}
+		out = binary.AppendUvarint(out, uint64(len(body)))
+		out = append(out, body...)
+		replaced += m
+	}
+
+	return out, replaced, nil
+}
+
+func rewriteBody(body []byte, growFunction uint64) ([]byte, int, error) {
+	r := &codeReader{b: body}
+
+	locals, err := r.uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	for n := uint64(0); n < locals; n++ {
+		if err := r.skipLEB(1); err != nil {
+			return nil, 0, err
+		}
+		if err := r.skip(1); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	out := append([]byte(nil), body[:r.pos]...)
+	replaced := 0
+	for r.pos < len(body) {
+		start := r.pos
+		op, _ := r.byte()
+
+		if op == opMemoryGrow {
+			memory, err := r.uvarint()
+			if err != nil {
+				return nil, 0, err
+			}
+			if memory != 0 {
+				return nil, 0, fmt.Errorf("memory.grow of memory %d cannot be tracked", memory)
+			}
+			out = append(out, opCall)
+			out = binary.AppendUvarint(out, growFunction)
+			replaced++
+			continue
+		}
+
+		if err := r.skipImmediates(op); err != nil {
+			return nil, 0, fmt.Errorf("instruction %#x at %d: %v", op, start, err)
+		}
+		out = append(out, body[start:r.pos]...)
+	}
+
+	return out, replaced, nil
+}
+
+// codeReader walks the instructions of a function body, knowing only enough
+// of them to skip their immediates
+type codeReader struct {
+	b   []byte
+	pos int
+}
+
+var errTruncated = errors.New("truncated module")
+
+func (r *codeReader) byte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, errTruncated
+	}
+	r.pos++
+	return r.b[r.pos-1], nil
+}
+
+func (r *codeReader) skip(n int) error {
+	if n < 0 || r.pos+n > len(r.b) {
+		return errTruncated
+	}
+	r.pos += n
+	return nil
+}
+
+func (r *codeReader) uvarint() (uint64, error) {
+	value, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.pos += n
+	return value, nil
+}
+
+// skipLEB skips count integers encoded as LEB128, signed or not
+func (r *codeReader) skipLEB(count int) error {
+	for ; count > 0; count-- {
+		for {
+			b, err := r.byte()
+			if err != nil {
+				return err
+			}
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (r *codeReader) skipLimits() error {
+	flags, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if flags&0x01 != 0 {
+		return r.skipLEB(2)
+	}
+	return r.skipLEB(1)
+}
+
+func (r *codeReader) skipImmediates(op byte) error {
+	switch {
+	case op == 0x00 || op == 0x01 || op == 0x05 || op == opEnd || op == 0x0f || op == 0x1a || op == 0x1b || op == 0xd1:
+		return nil
+	case op >= 0x45 && op <= 0xc4:
+		return nil
+	case op == opBlock || op == opLoop || op == opIf:
+		// Block types are a single byte or a type index, both read as LEB128
+		return r.skipLEB(1)
+	case op == 0x0c || op == 0x0d || op == opCall || op == 0xd0 || op == 0xd2:
+		return r.skipLEB(1)
+	case op >= opLocalGet && op <= 0x26:
+		return r.skipLEB(1)
+	case op == 0x0e:
+		labels, err := r.uvarint()
+		if err != nil {
+			return err
+		}
+		return r.skipLEB(int(labels) + 1)
+	case op == 0x11 || (op >= 0x28 && op <= 0x3e):
+		return r.skipLEB(2)
+	case op == 0x1c:
+		types, err := r.uvarint()
+		if err != nil {
+			return err
+		}
+		return r.skip(int(types))
+	case op == 0x3f || op == opI32Const || op == 0x42:
+		return r.skipLEB(1)
+	case op == 0x43:
+		return r.skip(4)
+	case op == 0x44:
+		return r.skip(8)
+	case op == opPrefixMisc:
+		sub, err := r.uvarint()
+		if err != nil {
+			return err
+		}
+		switch {
+		case sub <= 7:
+			return nil
+		case sub == 8 || sub == 10 || sub == 12 || sub == 14:
+			return r.skipLEB(2)
+		case sub <= 17:
+			return r.skipLEB(1)
+		}
+		return fmt.Errorf("unsupported instruction %#x %d", op, sub)
+	case op == opPrefixSIMD:
+		sub, err := r.uvarint()
+		if err != nil {
+			return err
+		}
+		switch {
+		case sub <= 11 || sub == 92 || sub == 93:
+			return r.skipLEB(2)
+		case sub == 12 || sub == 13:
+			return r.skip(16)
+		case sub >= 21 && sub <= 34:
+			return r.skip(1)
+		case sub >= 84 && sub <= 91:
+			if err := r.skipLEB(2); err != nil {
+				return err
+			}
+			return r.skip(1)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported instruction %#x", op)
+}
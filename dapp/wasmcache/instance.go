@@ -0,0 +1,332 @@
+package wasmcache
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
+	wasmContext "github.com/rubixchain/rubix-wasm/go-wasm-bridge/context"
+)
+
+// UNLIMITED_FUEL is the fuel given to instances created without a fuel budget
+const UNLIMITED_FUEL = 1 << 62
+
+// NO_EPOCH_DEADLINE is the epoch deadline of instances called without a deadline
+const NO_EPOCH_DEADLINE = 1 << 62
+
+var (
+	ErrTimedOut  = errors.New("timed out")
+	ErrOutOfFuel = errors.New("out of fuel")
+)
+
+// Instance is a single-use instantiation of a cached module. It mirrors
+// wasmbridge.WasmModule, but is created without reading or compiling the
+// artifact.
+type Instance struct {
+	store       *wasmtime.Store
+	instance    *wasmtime.Instance
+	memory      *wasmtime.Memory
+	allocFunc   *wasmtime.Func
+	deallocFunc *wasmtime.Func
+
+	nodeAddress string
+	quorumType  int
+	wasmCtx     *wasmContext.WasmContext
+	fuel        uint64
+	limits      Limits
+}
+
+// InstanceOption allows us to configure an Instance
+type InstanceOption func(*Instance)
+
+func WithRubixNodeAddress(nodeAddress string) InstanceOption {
+	return func(i *Instance) {
+		i.nodeAddress = nodeAddress
+	}
+}
+
+func WithQuorumType(quorumType int) InstanceOption {
+	return func(i *Instance) {
+		i.quorumType = quorumType
+	}
+}
+
+func WithWasmContext(wasmCtx *wasmContext.WasmContext) InstanceOption {
+	return func(i *Instance) {
+		i.wasmCtx = wasmCtx
+	}
+}
+
+// WithFuel caps the fuel the instance may consume, from its instantiation
+// to the end of the contract call. A zero fuel leaves the instance unlimited.
+func WithFuel(fuel uint64) InstanceOption {
+	return func(i *Instance) {
+		i.fuel = fuel
+	}
+}
+
+// WithLimits caps the memory and tables of the instance
+func WithLimits(limits Limits) InstanceOption {
+	return func(i *Instance) {
+		i.limits = limits
+	}
+}
+
+// NewInstance instantiates the cached artifact name in a fresh store, linking
+// the host functions of registry
+func (c *Cache) NewInstance(name string, registry *wasmbridge.HostFunctionRegistry, opts ...InstanceOption) (*Instance, error) {
+	i := &Instance{
+		nodeAddress: "http://localhost:20000",
+		quorumType:  2,
+		store:       wasmtime.NewStore(c.engine),
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	module, err := c.module(name, i.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	// The engine meters fuel and checks epochs, so every store needs fuel and
+	// an epoch deadline before running any code, start functions included
+	if i.fuel == 0 {
+		i.fuel = UNLIMITED_FUEL
+	}
+	if err := i.store.AddFuel(i.fuel); err != nil {
+		return nil, fmt.Errorf("failed to add fuel to the store: %w", err)
+	}
+	i.store.SetEpochDeadline(NO_EPOCH_DEADLINE)
+
+	// Host functions registered later take precedence over those of the same
+	// name, so that the dapp can replace the ones predefined by the bridge
+	linker := wasmtime.NewLinker(c.engine)
+	linker.AllowShadowing(true)
+	for _, hf := range registry.GetHostFunctions() {
+		err := linker.Define("env", hf.Name(), wasmtime.NewFunc(
+			i.store,
+			hf.FuncType(),
+			hf.Callback(),
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to define host function %s: %w", hf.Name(), err)
+		}
+	}
+
+	i.instance, err = linker.Instantiate(i.store, module)
+	if err != nil {
+		return nil, err
+	}
+
+	memoryExport := i.instance.GetExport(i.store, "memory")
+	if memoryExport == nil || memoryExport.Memory() == nil {
+		return nil, errors.New("failed to find memory export")
+	}
+	i.memory = memoryExport.Memory()
+
+	allocExport := i.instance.GetExport(i.store, "alloc")
+	if allocExport == nil || allocExport.Func() == nil {
+		return nil, errors.New("failed to find alloc function")
+	}
+	i.allocFunc = allocExport.Func()
+
+	deallocExport := i.instance.GetExport(i.store, "dealloc")
+	if deallocExport == nil || deallocExport.Func() == nil {
+		return nil, errors.New("failed to find dealloc function")
+	}
+	i.deallocFunc = deallocExport.Func()
+
+	for _, hf := range registry.GetHostFunctions() {
+		hf.Initialize(i.allocFunc, i.deallocFunc, i.memory, i.nodeAddress, i.quorumType, i.wasmCtx)
+	}
+
+	return i, nil
+}
+
+// allocate allocates memory in WASM and copies the data.
+func (i *Instance) allocate(data []byte) (int32, error) {
+	size := len(data)
+	result, err := i.allocFunc.Call(i.store, size)
+	if err != nil {
+		return 0, err
+	}
+	ptr := result.(int32)
+	memoryData := i.memory.UnsafeData(i.store)
+	copy(memoryData[ptr:ptr+int32(size)], data)
+	return ptr, nil
+}
+
+// deallocate frees memory in WASM.
+func (i *Instance) deallocate(ptr int32, size int32) error {
+	_, err := i.deallocFunc.Call(i.store, ptr, size)
+	return err
+}
+
+// interruption returns the reason the call was interrupted, if it was cut
+// short by its fuel budget, its memory limit, its deadline or the
+// cancellation of ctx
+func (i *Instance) interruption(ctx context.Context, err error) error {
+	if consumed, ok := i.store.FuelConsumed(); ok && consumed >= i.fuel {
+		return ErrOutOfFuel
+	}
+
+	if i.memoryLimitHit() {
+		return ErrMemoryLimit
+	}
+
+	var trap *wasmtime.Trap
+	if errors.As(err, &trap) && trap.Code() != nil && *trap.Code() == wasmtime.Interrupt {
+		return ErrTimedOut
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrTimedOut
+	}
+
+	return ctx.Err()
+}
+
+// memoryLimitHit tells whether the contract was refused a memory growth which
+// would have taken its memory past the limit. The failure is usually turned
+// into a trap by the contract, or into an error it reports.
+func (i *Instance) memoryLimitHit() bool {
+	if i.limits.MaxMemory == 0 {
+		return false
+	}
+
+	export := i.instance.GetExport(i.store, GROW_FAILURE_EXPORT)
+	if export == nil || export.Global() == nil {
+		return false
+	}
+	pages := uint64(uint32(export.Global().Get(i.store).I32()))
+
+	return pages > 0 && (i.memory.Size(i.store)+pages)*WASM_PAGE_SIZE > i.limits.MaxMemory
+}
+
+// CallFunction invokes the exported WASM function named by the single key of
+// the JSON input and returns the contract output. The contract is interrupted
+// once the deadline of ctx is reached.
+func (i *Instance) CallFunction(ctx context.Context, args string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("%w: contract was not called", i.interruption(ctx, err))
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := max(time.Until(deadline), 0)
+		i.store.SetEpochDeadline(uint64(remaining/EPOCH_TICK) + 1)
+	}
+
+	var inputMap map[string]interface{}
+	err := json.Unmarshal([]byte(args), &inputMap)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse input JSON: %v", err)
+	}
+	if len(inputMap) != 1 {
+		return "", errors.New("input JSON must contain exactly one function")
+	}
+
+	var funcName string
+	var inputStruct interface{}
+	for key, value := range inputMap {
+		funcName = key
+		inputStruct = value
+	}
+
+	// Contract functions are exported with a '_' suffix by the Rust wrapper
+	wrapperFuncName := funcName + "_"
+
+	inputJSON, err := json.Marshal(inputStruct)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize input struct: %v", err)
+	}
+
+	inputPtr, err := i.allocate(inputJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate memory for input data: %v", err)
+	}
+	defer i.deallocate(inputPtr, int32(len(inputJSON)))
+
+	outputPtrPtr, err := i.allocate(make([]byte, 4))
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate memory for output_ptr_ptr: %v", err)
+	}
+	defer i.deallocate(outputPtrPtr, 4)
+
+	outputLenPtr, err := i.allocate(make([]byte, 4))
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate memory for output_len_ptr: %v", err)
+	}
+	defer i.deallocate(outputLenPtr, 4)
+
+	extern := i.instance.GetExport(i.store, wrapperFuncName)
+	if extern == nil {
+		return "", fmt.Errorf("function %s does not exist in the contract", funcName)
+	}
+
+	function := extern.Func()
+	if function == nil {
+		return "", fmt.Errorf("export %s is not a function", funcName)
+	}
+
+	ret, err := function.Call(i.store, inputPtr, len(inputJSON), outputPtrPtr, outputLenPtr)
+	if err != nil {
+		if cause := i.interruption(ctx, err); cause != nil {
+			return "", fmt.Errorf("%w: %v", cause, err)
+		}
+		return "", fmt.Errorf("error calling WASM function: %v", err)
+	}
+
+	retCode, ok := ret.(int32)
+	if !ok {
+		return "", errors.New("unexpected return type from WASM function")
+	}
+
+	memoryData := i.memory.UnsafeData(i.store)
+	if len(memoryData) < int(outputPtrPtr)+4 || len(memoryData) < int(outputLenPtr)+8 {
+		return "", errors.New("invalid memory access for output pointers")
+	}
+
+	outputPtr := int32(binary.LittleEndian.Uint32(memoryData[outputPtrPtr:]))
+	outputLen := int32(binary.LittleEndian.Uint64(memoryData[outputLenPtr:]))
+
+	if outputPtr < 0 || outputPtr+outputLen > int32(len(memoryData)) {
+		return "", errors.New("output data exceeds memory bounds")
+	}
+
+	outputData := make([]byte, outputLen)
+	copy(outputData, memoryData[outputPtr:outputPtr+outputLen])
+
+	var output interface{}
+	err = json.Unmarshal(outputData, &output)
+	if err != nil {
+		return "", fmt.Errorf("failed to deserialize output data: %v", err)
+	}
+
+	err = i.deallocate(outputPtr, outputLen)
+	if err != nil {
+		return "", fmt.Errorf("failed to deallocate output data: %v", err)
+	}
+
+	contractOutputStr, ok := output.(string)
+	if !ok {
+		return "", fmt.Errorf("expected output of contract to be string type")
+	}
+
+	if retCode != 0 {
+		// The contract may report the failure of a host function which was
+		// abandoned when the deadline was reached
+		if cause := i.interruption(ctx, nil); cause != nil {
+			return "", fmt.Errorf("%w: %v", cause, contractOutputStr)
+		}
+		return "", fmt.Errorf("contract execution failed: %v", contractOutputStr)
+	}
+
+	return contractOutputStr, nil
+}
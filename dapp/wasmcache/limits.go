@@ -0,0 +1,134 @@
+package wasmcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// WASM_PAGE_SIZE is the size of a page of linear memory
+const WASM_PAGE_SIZE = 64 * 1024
+
+const (
+	sectionTable  = 4
+	sectionMemory = 5
+
+	limitsMin    = 0x00
+	limitsMinMax = 0x01
+)
+
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// Limits caps the linear memory, in bytes, and the number of table elements
+// of an instance. A zero limit leaves the instance uncapped.
+//
+// The bindings of wasmtime give no store limiter, so the limits are written
+// as the maximum sizes of the memories and tables declared by the module,
+// which the engine enforces: growing past them fails like on any module
+// declaring a maximum. The growth refused by the engine is recorded by the
+// module, see trackMemoryGrow.
+type Limits struct {
+	MaxMemory        uint64
+	MaxTableElements uint32
+}
+
+func (l Limits) memoryPages() uint64 {
+	return l.MaxMemory / WASM_PAGE_SIZE
+}
+
+// applyLimits returns the module wasmBytes with the maximum sizes of its
+// memories and tables lowered to limits, and its memory growth tracked. A
+// module whose memories or tables start out larger than limits is rejected.
+func applyLimits(wasmBytes []byte, limits Limits) ([]byte, error) {
+	sections, err := readSections(wasmBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	for n, s := range sections {
+		switch {
+		case s.id == sectionMemory && limits.MaxMemory > 0:
+			sections[n].payload, err = limitEntries(s.payload, func(r *bytes.Reader, w *bytes.Buffer) error {
+				return limitSize(r, w, limits.memoryPages(), "memory pages")
+			})
+		case s.id == sectionTable && limits.MaxTableElements > 0:
+			sections[n].payload, err = limitEntries(s.payload, func(r *bytes.Reader, w *bytes.Buffer) error {
+				refType, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				w.WriteByte(refType)
+				return limitSize(r, w, uint64(limits.MaxTableElements), "table elements")
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if limits.MaxMemory > 0 {
+		sections, err = trackMemoryGrow(sections)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return writeSections(wasmBytes[:8], sections), nil
+}
+
+// limitEntries rewrites every entry of a memory or table section with limit
+func limitEntries(payload []byte, limit func(r *bytes.Reader, w *bytes.Buffer) error) ([]byte, error) {
+	r := bytes.NewReader(payload)
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("malformed section: %v", err)
+	}
+
+	w := new(bytes.Buffer)
+	w.Write(binary.AppendUvarint(nil, count))
+	for n := uint64(0); n < count; n++ {
+		if err := limit(r, w); err != nil {
+			return nil, err
+		}
+	}
+	if r.Len() > 0 {
+		return nil, errors.New("malformed section: trailing bytes")
+	}
+
+	return w.Bytes(), nil
+}
+
+// limitSize reads the limits of a memory or table, and writes them back with
+// a maximum of at most limit
+func limitSize(r *bytes.Reader, w *bytes.Buffer, limit uint64, unit string) error {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if flags != limitsMin && flags != limitsMinMax {
+		return fmt.Errorf("unsupported limits flags %#x, shared and 64-bit memories and tables cannot be limited", flags)
+	}
+
+	initial, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("malformed limits: %v", err)
+	}
+	if initial > limit {
+		return fmt.Errorf("%w: the module starts with %d %s, %d allowed", ErrMemoryLimit, initial, unit, limit)
+	}
+
+	maximum := limit
+	if flags == limitsMinMax {
+		declared, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("malformed limits: %v", err)
+		}
+		maximum = min(maximum, declared)
+	}
+
+	w.WriteByte(limitsMinMax)
+	w.Write(binary.AppendUvarint(nil, initial))
+	w.Write(binary.AppendUvarint(nil, maximum))
+	return nil
+}
@@ -0,0 +1,230 @@
+package wasmcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	wasmbridge "github.com/rubixchain/rubix-wasm/go-wasm-bridge"
+)
+
+// growingContract starts with the given memory and table, and grows its
+// memory by a page until it cannot, before aborting like a Rust contract
+// whose allocator fails
+const growingContract = `
+(module
+  (memory (export "memory") %d)
+  (table %d funcref)
+  (global $heap (mut i32) (i32.const 1024))
+  (func (export "alloc") (param $size i32) (result i32)
+    (local $ptr i32)
+    (local.set $ptr (global.get $heap))
+    (global.set $heap (i32.add (global.get $heap) (local.get $size)))
+    (local.get $ptr))
+  (func (export "dealloc") (param i32 i32))
+  (func (export "grow_") (param i32 i32 i32 i32) (result i32)
+    (loop $grow
+      (br_if $grow (i32.ne (memory.grow (i32.const 1)) (i32.const -1))))
+    unreachable))
+`
+
+func newTestCache(t *testing.T, artifacts map[string]string) *Cache {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, wat := range artifacts {
+		wasmBytes, err := wasmtime.Wat2Wasm(wat)
+		if err != nil {
+			t.Fatalf("invalid test module %v: %v", name, err)
+		}
+		if err := os.WriteFile(path.Join(dir, name), wasmBytes, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cache.Close)
+	return cache
+}
+
+func TestLimits(t *testing.T) {
+	cache := newTestCache(t, map[string]string{
+		"small.wasm": fmt.Sprintf(growingContract, 1, 4),
+		"large.wasm": fmt.Sprintf(growingContract, 32, 4),
+		"table.wasm": fmt.Sprintf(growingContract, 1, 200),
+	})
+
+	tests := []struct {
+		name        string
+		artifact    string
+		limits      Limits
+		instanceErr error
+		callErr     error
+	}{
+		{
+			name:     "memory grows up to the limit",
+			artifact: "small.wasm",
+			limits:   Limits{MaxMemory: 16 * WASM_PAGE_SIZE},
+			callErr:  ErrMemoryLimit,
+		},
+		{
+			name:        "memory starting above the limit",
+			artifact:    "large.wasm",
+			limits:      Limits{MaxMemory: 16 * WASM_PAGE_SIZE},
+			instanceErr: ErrMemoryLimit,
+		},
+		{
+			name:        "table starting above the limit",
+			artifact:    "table.wasm",
+			limits:      Limits{MaxMemory: 16 * WASM_PAGE_SIZE, MaxTableElements: 100},
+			instanceErr: ErrMemoryLimit,
+		},
+		{
+			name:     "table within the limit",
+			artifact: "table.wasm",
+			limits:   Limits{MaxMemory: 16 * WASM_PAGE_SIZE, MaxTableElements: 200},
+			callErr:  ErrMemoryLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance, err := cache.NewInstance(tt.artifact, wasmbridge.NewHostFunctionRegistry(), WithLimits(tt.limits))
+			if !errors.Is(err, tt.instanceErr) {
+				t.Fatalf("NewInstance() error = %v, want %v", err, tt.instanceErr)
+			}
+			if err != nil {
+				return
+			}
+
+			_, err = instance.CallFunction(context.Background(), `{"grow": {}}`)
+			if !errors.Is(err, tt.callErr) {
+				t.Fatalf("CallFunction() error = %v, want %v", err, tt.callErr)
+			}
+
+			if pages := instance.memory.Size(instance.store); pages*WASM_PAGE_SIZE > tt.limits.MaxMemory {
+				t.Fatalf("memory grew to %d pages, beyond the limit of %d bytes", pages, tt.limits.MaxMemory)
+			}
+		})
+	}
+}
+
+func TestApplyLimitsKeepsLowerMaximum(t *testing.T) {
+	wasmBytes, err := wasmtime.Wat2Wasm(`(module (memory (export "memory") 1 4))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limited, err := applyLimits(wasmBytes, Limits{MaxMemory: 16 * WASM_PAGE_SIZE})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(wasmtime.NewEngine(), limited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoryType := module.Exports()[0].Type().MemoryType()
+	if ok, maximum := memoryType.Maximum(); !ok || maximum != 4 {
+		t.Fatalf("maximum = %d (%v), want 4", maximum, ok)
+	}
+}
+
+// trappingContract fails with the given memory, without growing it
+const trappingContract = `
+(module
+  (memory (export "memory") %d)
+  (func (export "alloc") (param i32) (result i32) (i32.const 1024))
+  (func (export "dealloc") (param i32 i32))
+  (func (export "grow_") (param i32 i32 i32 i32) (result i32)
+    unreachable))
+`
+
+// largeGrowthContract asks for 64 pages at once, and aborts when refused
+const largeGrowthContract = `
+(module
+  (memory (export "memory") 1)
+  (func (export "alloc") (param i32) (result i32) (i32.const 1024))
+  (func (export "dealloc") (param i32 i32))
+  (func (export "grow_") (param i32 i32 i32 i32) (result i32)
+    (if (i32.eq (memory.grow (i32.const 64)) (i32.const -1))
+      (then unreachable))
+    (i32.const 0)))
+`
+
+func TestMemoryLimitHit(t *testing.T) {
+	cache := newTestCache(t, map[string]string{
+		"growing.wasm": fmt.Sprintf(growingContract, 1, 4),
+		"large.wasm":   largeGrowthContract,
+		"full.wasm":    fmt.Sprintf(trappingContract, 16),
+	})
+
+	tests := []struct {
+		name     string
+		artifact string
+		limitHit bool
+	}{
+		{name: "page refused at the limit", artifact: "growing.wasm", limitHit: true},
+		{name: "large growth refused below the limit", artifact: "large.wasm", limitHit: true},
+		{name: "trap with the memory at the limit", artifact: "full.wasm", limitHit: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance, err := cache.NewInstance(tt.artifact, wasmbridge.NewHostFunctionRegistry(), WithLimits(Limits{MaxMemory: 16 * WASM_PAGE_SIZE}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = instance.CallFunction(context.Background(), `{"grow": {}}`)
+			if err == nil {
+				t.Fatal("CallFunction() succeeded, want a failure")
+			}
+			if limitHit := errors.Is(err, ErrMemoryLimit); limitHit != tt.limitHit {
+				t.Fatalf("CallFunction() error = %v, want the memory limit hit %v", err, tt.limitHit)
+			}
+		})
+	}
+}
+
+func TestTrackMemoryGrowKeepsIndices(t *testing.T) {
+	wasmBytes, err := wasmtime.Wat2Wasm(`
+(module
+  (import "env" "f" (func $f (param i32)))
+  (import "env" "g" (global $g i32))
+  (memory 1)
+  (global $h (mut i32) (i32.const 0))
+  (func (export "grow") (param i32) (result i32)
+    (call $f (global.get $g))
+    (global.set $h (memory.grow (local.get 0)))
+    (block $done
+      (br_table $done $done (global.get $h)))
+    (global.get $h)))
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limited, err := applyLimits(wasmBytes, Limits{MaxMemory: 16 * WASM_PAGE_SIZE})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(wasmtime.NewEngine(), limited)
+	if err != nil {
+		t.Fatalf("limited module is invalid: %v", err)
+	}
+	for _, export := range module.Exports() {
+		if export.Name() == GROW_FAILURE_EXPORT && export.Type().GlobalType() != nil {
+			return
+		}
+	}
+	t.Fatalf("limited module does not export %v", GROW_FAILURE_EXPORT)
+}
@@ -1,15 +1,60 @@
 package main
 
 import (
+	"dapp/events"
+	"dapp/wallet"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
+const DEFAULT_WS_PING_INTERVAL = 10 * time.Second
+const DEFAULT_WS_PONG_TIMEOUT = 30 * time.Second
+const DEFAULT_WS_RECONNECT_GRACE = 30 * time.Second
+
+// durationFromEnv reads a duration such as "45s" from the environment
+// variable name, and falls back to def when it is not set. Zero disables
+// the feature the duration configures.
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		fmt.Printf("invalid %v %q, using %v\n", name, value, def)
+		return def
+	}
+	return duration
+}
+
+// heartbeatOption configures the heartbeat of the sockets from the
+// WS_PING_INTERVAL and WS_PONG_TIMEOUT environment variables
+func heartbeatOption() wallet.BrokerOption {
+	pingInterval := durationFromEnv("WS_PING_INTERVAL", DEFAULT_WS_PING_INTERVAL)
+	pongTimeout := durationFromEnv("WS_PONG_TIMEOUT", DEFAULT_WS_PONG_TIMEOUT)
+	if pingInterval == 0 {
+		pongTimeout = 0
+	}
+
+	return wallet.WithHeartbeat(pingInterval, pongTimeout)
+}
+
+// brokerOptions configures the heartbeat and reconnect grace period of the
+// wallet sessions, the latter from the WS_RECONNECT_GRACE environment
+// variable
+func brokerOptions() []wallet.BrokerOption {
+	return []wallet.BrokerOption{
+		heartbeatOption(),
+		wallet.WithReconnectGrace(durationFromEnv("WS_RECONNECT_GRACE", DEFAULT_WS_RECONNECT_GRACE)),
+	}
+}
+
 func handleSocketConnection(w http.ResponseWriter, r *http.Request) {
 	enableCors(&w)
 
@@ -26,7 +71,7 @@ func handleSocketConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println("List of clients: ", TrieClientsMap)
+	fmt.Println("List of clients: ", TrieClients.DIDs())
 
 	if tcpConn, ok := conn.UnderlyingConn().(*net.TCPConn); ok {
 		tcpConn.SetKeepAlive(true)
@@ -36,55 +81,119 @@ func handleSocketConnection(w http.ResponseWriter, r *http.Request) {
 	conn.SetCloseHandler(func(code int, text string) error {
 		defer conn.Close()
 		fmt.Printf("Client disconnected (CLOSING): %v, errCode: %v, txt: %v", clientID, code, text)
-		delete(TrieClientsMap, clientID)
 		return nil
 	})
 
-	_, msgOpen, err := conn.ReadMessage()
+	// The wallet opens its session by declaring the fields of the extension
+	// commands it understands, so that a mismatch fails the connection
+	// rather than a command
+	schema, err := wallet.Handshake(conn)
 	if err != nil {
-		fmt.Println("Error reading message when at OPEN phase :", err)
-		delete(TrieClientsMap, clientID)
-	}
-
-	fmt.Println("Message received when at OPEN phase: ", string(msgOpen))
-	// go func() {
-	// 	ticker := time.NewTicker(4 * time.Second)
-	// 	defer ticker.Stop()
-	// 	for {
-	// 		select {
-	// 		case <-ticker.C:
-	// 			conn.SetWriteDeadline(time.Now().Add(4 * time.Second))
-	// 			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-	// 				log.Println("ping error:", err)
-	// 				return
-	// 			} else {
-	// 				fmt.Println("Ping sent successfully")
-	// 			}
-	// 		}
-	// 	}
-	// }()
+		fmt.Printf("Client handshake failed: %v, err: %v\n", clientID, err)
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, "unsupported schema"), time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+
+	fmt.Printf("Client %v opened its session with schema v%v\n", clientID, schema.Version)
 
 	conn.SetPingHandler(func(appData string) error {
 		fmt.Println(fmt.Sprintf("Ping received: %v\n", appData))
 		return nil
 	})
-	// conn.SetPongHandler(func(appData string) error {
-	// 	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	// 	fmt.Println("Pong received: ", appData)
-	// 	return nil
-	// })
 
-	TrieClientsMap[clientID] = conn
+	// The wallet must prove that it holds the key of the DID it connects as
+	// before any session is registered or resumed for it
+	if err := wallet.Authenticate(conn, clientID, verifyDIDSignature); err != nil {
+		fmt.Printf("Client authentication failed: %v, err: %v\n", clientID, err)
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authentication failed"), time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+
+	// A wallet reconnecting within the grace period resumes its session,
+	// along with the command it has not replied to yet
+	if sessionID := r.URL.Query().Get("sessionID"); sessionID != "" {
+		session, err := TrieClients.Resume(clientID, sessionID, conn, schema)
+		if err == nil {
+			fmt.Printf("Client reconnected: %v (session %v)\n", clientID, session.ID)
+			if err := session.Broker.Push("SESSION", TrieClients.Snapshot(session)); err != nil {
+				fmt.Printf("unable to send session %v to %v, err: %v\n", session.ID, clientID, err)
+			}
+			return
+		}
+		fmt.Println(err)
+	}
 
-	select {}
+	// The wallet is pushed the events about its DID, and can subscribe to
+	// more of them. The subscription lasts as long as the session.
+	sub, err := EventBus.Subscribe(clientID, events.Filter{DIDs: []string{clientID}})
+	if err != nil {
+		fmt.Printf("Client connection refused: %v, err: %v\n", clientID, err)
+		conn.Close()
+		return
+	}
+	defer sub.Close()
+
+	// The broker owns the connection from here on, it is the only reader
+	// and writer of the socket until the wallet disconnects for longer
+	// than the reconnect grace period
+	opts := append(brokerOptions(), wallet.WithSchema(schema))
+	opts = append(opts, subscriptionHandlers(sub)...)
+	broker := wallet.NewBroker(conn, opts...)
+	release, err := Sockets.Track(broker)
+	if err != nil {
+		fmt.Printf("Client connection refused: %v, err: %v\n", clientID, err)
+		return
+	}
+	defer release()
+
+	session := TrieClients.Add(clientID, broker)
+	go forwardEvents(sub, broker)
+
+	// The wallet is told its session ID, with which it can make itself the
+	// active session of its DID
+	if err := broker.Push("SESSION", TrieClients.Snapshot(session)); err != nil {
+		fmt.Printf("unable to send session %v to %v, err: %v\n", session.ID, clientID, err)
+	}
+
+	<-broker.Done()
+	TrieClients.Remove(session)
+	fmt.Printf("Client connection closed: %v (session %v), err: %v\n", clientID, session.ID, broker.Err())
 }
 
 func (s *Server) handleConnectedClients(c *gin.Context) {
-	clientIDs := make([]string, 0, len(TrieClientsMap))
-	for clientID := range TrieClientsMap {
-		clientIDs = append(clientIDs, clientID)
+	c.JSON(http.StatusOK, TrieClients.DIDs())
+}
+
+func (s *Server) handleClientSessions(c *gin.Context) {
+	clientID := c.Query("clientID")
+	if clientID == "" {
+		c.JSON(http.StatusBadRequest, "clientID is required")
+		return
+	}
+
+	c.JSON(http.StatusOK, TrieClients.Sessions(clientID))
+}
+
+// handleSetActiveSession chooses the session of a DID to which wallet
+// commands are sent, instead of its most recent one
+func (s *Server) handleSetActiveSession(c *gin.Context) {
+	w := http.ResponseWriter(c.Writer)
+	enableCors(&w)
+
+	clientID := c.Query("clientID")
+	sessionID := c.Query("sessionID")
+	if clientID == "" || sessionID == "" {
+		c.JSON(http.StatusBadRequest, "clientID and sessionID are required")
+		return
+	}
+
+	if err := TrieClients.SetActive(clientID, sessionID); err != nil {
+		c.JSON(http.StatusNotFound, err.Error())
+		return
 	}
-	c.JSON(http.StatusOK, clientIDs)
+	c.JSON(http.StatusOK, TrieClients.Sessions(clientID))
 }
 
 func (s *Server) handlePingClient(c *gin.Context) {
@@ -94,13 +203,13 @@ func (s *Server) handlePingClient(c *gin.Context) {
 		return
 	}
 
-	conn, ok := TrieClientsMap[clientID]
+	session, ok := TrieClients.Get(clientID)
 	if !ok {
 		c.JSON(http.StatusNotFound, "Client not found")
 		return
 	}
 
-	err := conn.WriteMessage(websocket.PingMessage, []byte("ping"))
+	err := session.Broker.Ping([]byte("ping"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, fmt.Sprintf("Failed to send ping: %v", err))
 		return
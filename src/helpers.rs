@@ -1,4 +1,5 @@
-use super::imports::{do_mint_nft_trie, do_transfer_ft_trie, do_create_ft};
+use super::imports::{do_mint_nft_trie, do_transfer_ft_trie, do_create_ft, do_transfer_nft, do_get_ft_balance, do_get_credit_balance, do_deduct_credit, do_read_asset_metadata};
+use serde::de::DeserializeOwned;
 use std::str;
 use serde::{Serialize,Deserialize};
 use rubixwasm_std::errors::WasmError;
@@ -30,6 +31,74 @@ pub struct TransferFt{
     pub receiver: String,
 }
 
+#[derive(Serialize, Deserialize)]
+pub struct TransferNft {
+    pub nft: String,
+    pub owner: String,
+    pub receiver: String,
+    pub comment: String,
+    pub nft_value: f64,
+    pub nft_data: String,
+    pub quorum_type: i32,
+}
+
+#[derive(Serialize, Deserialize)]
+pub struct TransferNftResponse {
+    pub nftId: String,
+    pub txId: String
+}
+
+#[derive(Serialize, Deserialize)]
+pub struct GetFtBalance {
+    pub did: String,
+    pub ft_name: String,
+    pub creatorDID: String,
+}
+
+#[derive(Serialize, Deserialize)]
+pub struct FtBalance {
+    pub did: String,
+    pub ft_name: String,
+    pub ft_count: i32,
+}
+
+#[derive(Serialize, Deserialize)]
+pub struct GetCreditBalance {
+    pub user_did: String,
+}
+
+#[derive(Serialize, Deserialize)]
+pub struct CreditBalance {
+    pub user_did: String,
+    pub credit: u32,
+}
+
+#[derive(Serialize, Deserialize)]
+pub struct DeductCredit {
+    pub user_did: String,
+    pub amount: u32,
+    pub reason: String,
+    pub asset_id: String,
+}
+
+#[derive(Serialize, Deserialize)]
+pub struct CreditDeduction {
+    pub user_did: String,
+    pub deducted: u32,
+    pub credit: u32,
+}
+
+#[derive(Serialize, Deserialize)]
+pub struct ReadAssetMetadata {
+    pub nft: String,
+}
+
+#[derive(Serialize, Deserialize)]
+pub struct AssetMetadata {
+    pub nft: String,
+    pub metadata: serde_json::Map<String, serde_json::Value>,
+}
+
 #[derive(Serialize, Deserialize)]
 pub struct CreateFt {
     pub did: String,
@@ -166,4 +235,74 @@ pub fn call_create_ft_api(create_ft: CreateFt) -> Result<CreateFtResponse, WasmE
             Err(_) => Err(WasmError::from("Invalid UTF-8 response".to_string())),
         }
     }
+}
+
+type HostFn = unsafe extern "C" fn(*const u8, usize, *mut *const u8, *mut usize) -> i32;
+
+// call_host sends the input as JSON to a host function and parses its JSON
+// response
+fn call_host<I: Serialize, O: DeserializeOwned>(host_fn: HostFn, input: &I) -> Result<O, WasmError> {
+    unsafe {
+        let input_bytes = serde_json::to_string(input).unwrap().into_bytes();
+
+        let input_ptr = input_bytes.as_ptr();
+        let input_len = input_bytes.len();
+
+        let mut resp_ptr: *const u8 = std::ptr::null();
+        let mut resp_len: usize = 0;
+
+        let result = host_fn(
+            input_ptr,
+            input_len,
+            &mut resp_ptr,
+            &mut resp_len,
+        );
+
+        // A host function which does not trap may describe its failure in
+        // the response, as {"outcome": ..., "code": ..., "error": ...}
+        if result != 0 && resp_ptr.is_null() {
+            return Err(WasmError::from(format!("Host function returned error code {}", result)));
+        }
+
+        if resp_ptr.is_null() {
+            return Err(WasmError::from("Response pointer is null".to_string()));
+        }
+
+        let response_slice = slice::from_raw_parts(resp_ptr, resp_len);
+        if result != 0 {
+            return Err(WasmError::from(format!("Host function returned error code {}: {}", result, String::from_utf8_lossy(response_slice))));
+        }
+        match str::from_utf8(response_slice) {
+            Ok(s) => serde_json::from_str::<O>(s)
+                .map_err(|e| WasmError::from(format!("Invalid host function response: {}", e))),
+            Err(_) => Err(WasmError::from("Invalid UTF-8 response".to_string())),
+        }
+    }
+}
+
+// The owner approves the transfer in their wallet before it returns
+pub fn call_transfer_nft_api(transfer_nft: TransferNft) -> Result<TransferNftResponse, WasmError> {
+    call_host(do_transfer_nft, &transfer_nft)
+}
+
+pub fn call_get_ft_balance_api(get_ft_balance: GetFtBalance) -> Result<FtBalance, WasmError> {
+    call_host(do_get_ft_balance, &get_ft_balance)
+}
+
+pub fn call_get_credit_balance_api(user_did: String) -> Result<CreditBalance, WasmError> {
+    call_host(do_get_credit_balance, &GetCreditBalance { user_did })
+}
+
+// Host function code returned by do_deduct_credit when the balance is too low
+pub const CODE_INSUFFICIENT_CREDITS: i32 = 5;
+
+// The credits can only be deducted from the initiator of the contract. When
+// the balance is too low, the error names CODE_INSUFFICIENT_CREDITS and holds
+// the outcome written by the host function.
+pub fn call_deduct_credit_api(deduct_credit: DeductCredit) -> Result<CreditDeduction, WasmError> {
+    call_host(do_deduct_credit, &deduct_credit)
+}
+
+pub fn call_read_asset_metadata_api(nft: String) -> Result<AssetMetadata, WasmError> {
+    call_host(do_read_asset_metadata, &ReadAssetMetadata { nft })
 }
\ No newline at end of file
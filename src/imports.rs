@@ -19,4 +19,39 @@ extern "C" {
         resp_ptr_ptr: *mut *const u8,
         resp_len_ptr: *mut usize,
     ) -> i32;
+
+    pub fn do_transfer_nft(
+        inputdata_ptr: *const u8,
+        inputdata_len: usize,
+        resp_ptr_ptr: *mut *const u8,
+        resp_len_ptr: *mut usize,
+    ) -> i32;
+
+    pub fn do_get_ft_balance(
+        inputdata_ptr: *const u8,
+        inputdata_len: usize,
+        resp_ptr_ptr: *mut *const u8,
+        resp_len_ptr: *mut usize,
+    ) -> i32;
+
+    pub fn do_get_credit_balance(
+        inputdata_ptr: *const u8,
+        inputdata_len: usize,
+        resp_ptr_ptr: *mut *const u8,
+        resp_len_ptr: *mut usize,
+    ) -> i32;
+
+    pub fn do_deduct_credit(
+        inputdata_ptr: *const u8,
+        inputdata_len: usize,
+        resp_ptr_ptr: *mut *const u8,
+        resp_len_ptr: *mut usize,
+    ) -> i32;
+
+    pub fn do_read_asset_metadata(
+        inputdata_ptr: *const u8,
+        inputdata_len: usize,
+        resp_ptr_ptr: *mut *const u8,
+        resp_len_ptr: *mut usize,
+    ) -> i32;
 }
\ No newline at end of file